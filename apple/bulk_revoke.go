@@ -0,0 +1,476 @@
+package apple
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Statuses of a BulkRevokeOutcome
+const (
+	// BulkRevoked means Apple revoked the token
+	BulkRevoked = "revoked"
+
+	// BulkAlreadyInvalid means Apple answered invalid_grant: the token was already revoked or expired
+	BulkAlreadyInvalid = "already_invalid"
+
+	// BulkFailed means the token could not be revoked within MaxAttempts. It is retried by the next run.
+	BulkFailed = "failed"
+)
+
+// BulkRevokeItem is one refresh token to revoke
+type BulkRevokeItem struct {
+	// Subject identifies the user in outcomes and progress. It is optional.
+	Subject string
+
+	// ClientID is the client ID the token was issued to
+	ClientID string
+
+	// RefreshToken is the token to revoke
+	RefreshToken string
+}
+
+// key identifies item in a BulkRevokeLog without storing the token itself
+func (item BulkRevokeItem) key() string {
+	sum := sha256.Sum256([]byte(item.ClientID + "\x00" + item.RefreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// RevokeIterator yields the tokens for a BulkRevoker. Next returns io.EOF after the last item.
+type RevokeIterator interface {
+	Next(ctx context.Context) (BulkRevokeItem, error)
+}
+
+type sliceRevokeIterator struct {
+	items []BulkRevokeItem
+}
+
+// NewSliceRevokeIterator returns a RevokeIterator over items
+func NewSliceRevokeIterator(items []BulkRevokeItem) RevokeIterator {
+	return &sliceRevokeIterator{items: items}
+}
+
+func (s *sliceRevokeIterator) Next(ctx context.Context) (BulkRevokeItem, error) {
+	if len(s.items) == 0 {
+		return BulkRevokeItem{}, io.EOF
+	}
+	item := s.items[0]
+	s.items = s.items[1:]
+	return item, nil
+}
+
+// BulkRevokeOutcome is the result of revoking one token, as written to a BulkRevokeLog
+type BulkRevokeOutcome struct {
+	// Key is a SHA-256 hash of the client ID and token, used to skip the token when resuming
+	Key string `json:"key"`
+
+	Subject  string `json:"subject,omitempty"`
+	ClientID string `json:"client_id"`
+
+	// Status is BulkRevoked, BulkAlreadyInvalid or BulkFailed
+	Status string `json:"status"`
+
+	// Attempts is the number of requests made to Apple
+	Attempts int `json:"attempts"`
+
+	// Error is the last error of a failed token
+	Error string `json:"error,omitempty"`
+}
+
+// BulkRevokeLog records per-token outcomes so an interrupted BulkRevoker run can resume
+type BulkRevokeLog interface {
+	// Done reports whether the token with key was already revoked or found invalid
+	Done(ctx context.Context, key string) (bool, error)
+
+	// Record stores the outcome of one token
+	Record(ctx context.Context, outcome BulkRevokeOutcome) error
+}
+
+// FileRevokeLog is a BulkRevokeLog kept as a file of JSON lines, one outcome per line. Tokens are
+// identified by hash, so the file holds no credentials.
+type FileRevokeLog struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]bool
+}
+
+// OpenFileRevokeLog opens the log at path, creating it if needed, and loads the outcomes of earlier runs
+func OpenFileRevokeLog(path string) (*FileRevokeLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var outcome BulkRevokeOutcome
+		if err := json.Unmarshal(scanner.Bytes(), &outcome); err != nil {
+			// The last line may be cut short by a crash; its token is simply revoked again
+			continue
+		}
+		done[outcome.Key] = outcome.Status != BulkFailed
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading revoke log %s: %w", path, err)
+	}
+
+	// Terminate a line cut short by a crash so new outcomes start on a line of their own
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := file.Write([]byte("\n")); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+	return &FileRevokeLog{file: file, done: done}, nil
+}
+
+// Done reports whether an earlier outcome for key was successful
+func (l *FileRevokeLog) Done(ctx context.Context, key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.done[key], nil
+}
+
+// Record appends outcome to the file
+func (l *FileRevokeLog) Record(ctx context.Context, outcome BulkRevokeOutcome) error {
+	line, err := json.Marshal(outcome)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.done[outcome.Key] = outcome.Status != BulkFailed
+	return nil
+}
+
+// Close closes the file
+func (l *FileRevokeLog) Close() error {
+	return l.file.Close()
+}
+
+// BulkRevokeProgress counts the tokens a BulkRevoker has handled so far
+type BulkRevokeProgress struct {
+	Revoked        int
+	AlreadyInvalid int
+	Failed         int
+
+	// Skipped counts tokens the log showed as done by an earlier run
+	Skipped int
+}
+
+// Processed is the number of tokens handled so far
+func (p BulkRevokeProgress) Processed() int {
+	return p.Revoked + p.AlreadyInvalid + p.Failed + p.Skipped
+}
+
+// BulkRevokerOptions configures a BulkRevoker
+type BulkRevokerOptions struct {
+	// Client sends the revocations, usually a *Client
+	Client TokenRevoker
+
+	// Secrets supplies client secrets. Leave it nil when Client is a *Client with ClientOptions.Secrets;
+	// one of the two is required, as BulkRevokeItem carries no secret.
+	Secrets SecretProvider
+
+	// Concurrency is the number of revocations in flight at once. Defaults to 4.
+	Concurrency int
+
+	// RatePerSecond caps the requests sent to Apple per second, retries included. Zero means no limit.
+	RatePerSecond float64
+
+	// MaxAttempts is the number of tries per token on 429, 5xx and network errors. Defaults to 5.
+	MaxAttempts int
+
+	// RetryBackoff is the delay before the first retry, doubled for each further one. Defaults to one second.
+	RetryBackoff time.Duration
+
+	// Log records outcomes and lets a new run skip tokens that are done. Optional.
+	Log BulkRevokeLog
+
+	// OnProgress is called after each token with the running totals. Calls are serialized.
+	OnProgress func(BulkRevokeProgress)
+}
+
+// BulkRevoker revokes large numbers of refresh tokens, for example when an app is shut down
+type BulkRevoker struct {
+	client       TokenRevoker
+	secrets      SecretProvider
+	concurrency  int
+	interval     time.Duration
+	maxAttempts  int
+	retryBackoff time.Duration
+	log          BulkRevokeLog
+	onProgress   func(BulkRevokeProgress)
+}
+
+// NewBulkRevoker creates a BulkRevoker. Client and a source of client secrets are required.
+func NewBulkRevoker(options BulkRevokerOptions) (*BulkRevoker, error) {
+	if options.Client == nil {
+		return nil, errors.New("client is required")
+	}
+	if c, ok := options.Client.(*Client); options.Secrets == nil && (!ok || c.Options().Secrets == nil) {
+		return nil, errors.New("client secrets are required: set Secrets or the client's ClientOptions.Secrets")
+	}
+	if options.Concurrency < 0 || options.RatePerSecond < 0 || options.MaxAttempts < 0 || options.RetryBackoff < 0 {
+		return nil, errors.New("concurrency, rate, attempts and backoff must not be negative")
+	}
+	if options.Concurrency == 0 {
+		options.Concurrency = 4
+	}
+	if options.MaxAttempts == 0 {
+		options.MaxAttempts = 5
+	}
+	if options.RetryBackoff == 0 {
+		options.RetryBackoff = time.Second
+	}
+
+	var interval time.Duration
+	if options.RatePerSecond > 0 {
+		interval = time.Duration(float64(time.Second) / options.RatePerSecond)
+	}
+	return &BulkRevoker{
+		client:       options.Client,
+		secrets:      options.Secrets,
+		concurrency:  options.Concurrency,
+		interval:     interval,
+		maxAttempts:  options.MaxAttempts,
+		retryBackoff: options.RetryBackoff,
+		log:          options.Log,
+		onProgress:   options.OnProgress,
+	}, nil
+}
+
+// Run revokes every token from items and returns the totals. Tokens that fail after MaxAttempts are
+// counted and logged, and do not stop the run. Run stops early, returning an error, when ctx is done,
+// the iterator fails, an outcome cannot be logged, or Apple rejects the client secret with
+// invalid_client, which would fail every remaining token alike. Running it again with the same log resumes.
+func (b *BulkRevoker) Run(ctx context.Context, items RevokeIterator) (BulkRevokeProgress, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		progress BulkRevokeProgress
+		runErr   error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if runErr == nil {
+			runErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+	report := func(update func(*BulkRevokeProgress)) {
+		mu.Lock()
+		defer mu.Unlock()
+		update(&progress)
+		if b.onProgress != nil {
+			b.onProgress(progress)
+		}
+	}
+
+	limiter := &rateLimiter{interval: b.interval}
+	work := make(chan BulkRevokeItem)
+	var wg sync.WaitGroup
+	for i := 0; i < b.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				outcome, err := b.revoke(ctx, limiter, item)
+				if err != nil {
+					// Interrupted or aborted: leave the token unlogged so the next run tries it again
+					if ctx.Err() == nil {
+						fail(err)
+					}
+					continue
+				}
+				if b.log != nil {
+					if err := b.log.Record(ctx, outcome); err != nil {
+						fail(fmt.Errorf("recording outcome: %w", err))
+						continue
+					}
+				}
+				report(func(p *BulkRevokeProgress) {
+					switch outcome.Status {
+					case BulkRevoked:
+						p.Revoked++
+					case BulkAlreadyInvalid:
+						p.AlreadyInvalid++
+					default:
+						p.Failed++
+					}
+				})
+			}
+		}()
+	}
+
+	b.feed(ctx, items, work, fail, report)
+	close(work)
+	wg.Wait()
+
+	if runErr == nil {
+		runErr = ctx.Err()
+	}
+	return progress, runErr
+}
+
+// feed sends the items that are not done yet to work until the iterator ends or the run is cancelled
+func (b *BulkRevoker) feed(ctx context.Context, items RevokeIterator, work chan<- BulkRevokeItem, fail func(error), report func(func(*BulkRevokeProgress))) {
+	for {
+		item, err := items.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			fail(fmt.Errorf("reading tokens: %w", err))
+			return
+		}
+
+		if b.log != nil {
+			done, err := b.log.Done(ctx, item.key())
+			if err != nil {
+				fail(fmt.Errorf("reading revoke log: %w", err))
+				return
+			}
+			if done {
+				report(func(p *BulkRevokeProgress) { p.Skipped++ })
+				continue
+			}
+		}
+
+		select {
+		case work <- item:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// revoke revokes item, retrying temporary failures. It returns an error when ctx ends first or when the
+// run must stop because the client secret is missing or rejected.
+func (b *BulkRevoker) revoke(ctx context.Context, limiter *rateLimiter, item BulkRevokeItem) (BulkRevokeOutcome, error) {
+	outcome := BulkRevokeOutcome{Key: item.key(), Subject: item.Subject, ClientID: item.ClientID}
+	req := RevokeRefreshTokenRequest{ClientID: item.ClientID, RefreshToken: item.RefreshToken}
+	if b.secrets != nil {
+		secret, err := b.secrets.ClientSecret(ctx, item.ClientID)
+		if err != nil {
+			return outcome, fmt.Errorf("client secret for %s: %w", item.ClientID, err)
+		}
+		req.ClientSecret = secret
+	}
+
+	backoff := b.retryBackoff
+	for {
+		if err := limiter.wait(ctx); err != nil {
+			return outcome, err
+		}
+		outcome.Attempts++
+		err := b.send(ctx, req)
+
+		var apiErr *APIError
+		switch {
+		case err == nil:
+			outcome.Status = BulkRevoked
+			return outcome, nil
+		case errors.As(err, &apiErr) && apiErr.Code == "invalid_grant":
+			outcome.Status = BulkAlreadyInvalid
+			return outcome, nil
+		case errors.As(err, &apiErr) && apiErr.Code == "invalid_client":
+			return outcome, fmt.Errorf("revoking tokens for %s: %w", item.ClientID, err)
+		case ctx.Err() != nil:
+			return outcome, ctx.Err()
+		}
+
+		retryable := !errors.As(err, &apiErr) || apiErr.Temporary()
+		if !retryable || outcome.Attempts >= b.maxAttempts {
+			outcome.Status = BulkFailed
+			outcome.Error = err.Error()
+			return outcome, nil
+		}
+		if err := sleepContext(ctx, backoff); err != nil {
+			return outcome, err
+		}
+		backoff *= 2
+	}
+}
+
+// send revokes the token of req and returns Apple's errors as an *APIError. A *Client is called through
+// Send to keep the HTTP status; other TokenRevokers only return the decoded body, so their errors are
+// reported with the 400 status Apple answers errors with.
+func (b *BulkRevoker) send(ctx context.Context, req RevokeRefreshTokenRequest) error {
+	if c, ok := b.client.(*Client); ok {
+		_, err := Send[RevokeResponse](ctx, c, req)
+		return err
+	}
+	var resp RevokeResponse
+	if err := b.client.RevokeRefreshToken(ctx, req, &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return &APIError{StatusCode: http.StatusBadRequest, Code: resp.Error, Description: resp.ErrorDescription}
+	}
+	return nil
+}
+
+// rateLimiter spaces out requests by interval in real time, as it has to actually wait. A zero interval
+// does not limit.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until the next request may be sent
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.interval <= 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	return sleepContext(ctx, delay)
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,206 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBulkRevokeServer answers revocations with respond, which gets the token and how often it was seen.
+// The returned client signs requests with the secret "secret".
+func newBulkRevokeServer(t *testing.T, respond func(token string, attempt int) (int, string)) *Client {
+	t.Helper()
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "secret", r.PostForm.Get("client_secret"))
+		token := r.PostForm.Get("token")
+		mu.Lock()
+		attempts[token]++
+		attempt := attempts[token]
+		mu.Unlock()
+
+		status, body := respond(token, attempt)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return NewWithOptions(ClientOptions{RevokeURL: srv.URL, Secrets: &staticSecretProvider{secret: "secret"}})
+}
+
+func bulkItems(n int) []BulkRevokeItem {
+	items := make([]BulkRevokeItem, n)
+	for i := range items {
+		items[i] = BulkRevokeItem{Subject: fmt.Sprintf("user%d", i), ClientID: "cid", RefreshToken: fmt.Sprintf("token%d", i)}
+	}
+	return items
+}
+
+func TestBulkRevoker(t *testing.T) {
+	c := newBulkRevokeServer(t, func(token string, attempt int) (int, string) {
+		switch {
+		case token == "token0" && attempt == 1:
+			return http.StatusTooManyRequests, ``
+		case token == "token1" && attempt == 1:
+			return http.StatusServiceUnavailable, `<html>down</html>`
+		case token == "token2":
+			return http.StatusBadRequest, `{"error":"invalid_grant"}`
+		case token == "token3":
+			return http.StatusBadRequest, `{"error":"invalid_request"}`
+		}
+		return http.StatusOK, ``
+	})
+
+	var last BulkRevokeProgress
+	calls := 0
+	revoker, err := NewBulkRevoker(BulkRevokerOptions{
+		Client:       c,
+		Concurrency:  3,
+		RetryBackoff: time.Millisecond,
+		OnProgress: func(p BulkRevokeProgress) {
+			calls++
+			last = p
+		},
+	})
+	require.NoError(t, err)
+
+	progress, err := revoker.Run(context.Background(), NewSliceRevokeIterator(bulkItems(10)))
+	require.NoError(t, err)
+	assert.Equal(t, BulkRevokeProgress{Revoked: 8, AlreadyInvalid: 1, Failed: 1}, progress, "429 and 503 are retried, invalid_request is not")
+	assert.Equal(t, progress, last)
+	assert.Equal(t, 10, calls)
+	assert.Equal(t, 10, progress.Processed())
+}
+
+func TestBulkRevokerResumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoke.log")
+	failing := true
+	c := newBulkRevokeServer(t, func(token string, attempt int) (int, string) {
+		if failing && token == "token4" {
+			return http.StatusInternalServerError, ``
+		}
+		return http.StatusOK, ``
+	})
+
+	log, err := OpenFileRevokeLog(path)
+	require.NoError(t, err)
+	revoker, err := NewBulkRevoker(BulkRevokerOptions{Client: c, MaxAttempts: 2, RetryBackoff: time.Millisecond, Log: log})
+	require.NoError(t, err)
+	progress, err := revoker.Run(context.Background(), NewSliceRevokeIterator(bulkItems(5)))
+	require.NoError(t, err)
+	assert.Equal(t, BulkRevokeProgress{Revoked: 4, Failed: 1}, progress)
+	require.NoError(t, log.Close())
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(contents), "token", "the log holds hashes, not tokens")
+	assert.Contains(t, string(contents), `"status":"failed","attempts":2`)
+
+	// Simulate a crash in the middle of writing an outcome
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"key":"trunc`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	failing = false
+	log, err = OpenFileRevokeLog(path)
+	require.NoError(t, err)
+	defer log.Close()
+	revoker, err = NewBulkRevoker(BulkRevokerOptions{Client: c, Log: log})
+	require.NoError(t, err)
+	progress, err = revoker.Run(context.Background(), NewSliceRevokeIterator(bulkItems(5)))
+	require.NoError(t, err)
+	assert.Equal(t, BulkRevokeProgress{Revoked: 1, Skipped: 4}, progress, "only the failed token is retried")
+
+	contents, err = os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	assert.Contains(t, lines[len(lines)-1], `"status":"revoked"`)
+}
+
+func TestBulkRevokerRateLimit(t *testing.T) {
+	c := newBulkRevokeServer(t, func(string, int) (int, string) { return http.StatusOK, `` })
+	revoker, err := NewBulkRevoker(BulkRevokerOptions{Client: c, Concurrency: 5, RatePerSecond: 100})
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = revoker.Run(context.Background(), NewSliceRevokeIterator(bulkItems(6)))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "six requests at 100/s take at least 50ms")
+}
+
+func TestBulkRevokerAbortsOnInvalidClient(t *testing.T) {
+	var requests int
+	c := newBulkRevokeServer(t, func(string, int) (int, string) {
+		requests++
+		return http.StatusBadRequest, `{"error":"invalid_client"}`
+	})
+	revoker, err := NewBulkRevoker(BulkRevokerOptions{Client: c, Concurrency: 1})
+	require.NoError(t, err)
+
+	progress, err := revoker.Run(context.Background(), NewSliceRevokeIterator(bulkItems(100)))
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "invalid_client", apiErr.Code)
+	assert.Equal(t, BulkRevokeProgress{}, progress, "the rejected token is left for the next run")
+	assert.Equal(t, 1, requests, "the run stops instead of sending every token with a bad secret")
+}
+
+func TestBulkRevokerSecrets(t *testing.T) {
+	fake := NewFakeClient().
+		Respond("RevokeRefreshToken", nil, nil).
+		Respond("RevokeRefreshToken", RevokeResponse{Error: "invalid_grant"}, nil)
+	revoker, err := NewBulkRevoker(BulkRevokerOptions{Client: fake, Secrets: &staticSecretProvider{secret: "signed"}, Concurrency: 1})
+	require.NoError(t, err)
+
+	progress, err := revoker.Run(context.Background(), NewSliceRevokeIterator(bulkItems(2)))
+	require.NoError(t, err)
+	assert.Equal(t, BulkRevokeProgress{Revoked: 1, AlreadyInvalid: 1}, progress)
+	calls := fake.CallsTo("RevokeRefreshToken")
+	require.Len(t, calls, 2)
+	assert.Equal(t, RevokeRefreshTokenRequest{ClientID: "cid", ClientSecret: "signed", RefreshToken: "token0"}, calls[0].Request)
+
+	_, err = NewBulkRevoker(BulkRevokerOptions{Client: fake})
+	assert.Error(t, err, "a TokenRevoker needs Secrets")
+	_, err = NewBulkRevoker(BulkRevokerOptions{Client: New()})
+	assert.Error(t, err, "a client without ClientOptions.Secrets needs Secrets")
+	_, err = NewBulkRevoker(BulkRevokerOptions{Client: New(), Secrets: &staticSecretProvider{secret: "signed"}})
+	assert.NoError(t, err)
+}
+
+type failingIterator struct{ err error }
+
+func (f failingIterator) Next(ctx context.Context) (BulkRevokeItem, error) {
+	return BulkRevokeItem{}, f.err
+}
+
+func TestBulkRevokerStops(t *testing.T) {
+	c := newBulkRevokeServer(t, func(string, int) (int, string) { return http.StatusOK, `` })
+	revoker, err := NewBulkRevoker(BulkRevokerOptions{Client: c})
+	require.NoError(t, err)
+
+	boom := errors.New("database gone")
+	_, err = revoker.Run(context.Background(), failingIterator{err: boom})
+	assert.ErrorIs(t, err, boom)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = revoker.Run(ctx, NewSliceRevokeIterator(bulkItems(3)))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = NewBulkRevoker(BulkRevokerOptions{})
+	assert.Error(t, err)
+}
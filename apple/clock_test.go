@@ -0,0 +1,28 @@
+package apple
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	clock.Advance(90 * time.Minute)
+	assert.Equal(t, start.Add(90*time.Minute), clock.Now())
+
+	later := start.AddDate(1, 0, 0)
+	clock.Set(later)
+	assert.Equal(t, later, clock.Now())
+}
+
+func TestClockOrDefault(t *testing.T) {
+	assert.Equal(t, SystemClock, clockOrDefault(nil))
+
+	fake := NewFakeClock(time.Unix(0, 0))
+	assert.Equal(t, Clock(fake), clockOrDefault(fake))
+}
@@ -0,0 +1,367 @@
+package apple
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that is written in configuration as a Go duration string such as "15m"
+// or "4320h". Plain JSON numbers are read as seconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		parsed, err := parseConfigDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	}
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return fmt.Errorf("duration must be a string such as \"15m\" or a number of seconds, got %s", data)
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config is the declarative configuration of a Sign in with Apple integration, loaded from a JSON
+// file, environment variables or both. It builds a Client and a SecretProvider so that every service
+// configures the library the same way.
+type Config struct {
+	// TeamID is your 10-character Team ID
+	TeamID string `json:"team_id,omitempty"`
+
+	// KeyID is the 10-character Key ID of the signing key
+	KeyID string `json:"key_id,omitempty"`
+
+	// ClientIDs are the Services IDs and App IDs (bundle IDs) tokens are issued for
+	ClientIDs []string `json:"client_ids,omitempty"`
+
+	// PrivateKeyPath is the path of the .p8 signing key. Exactly one of PrivateKeyPath and PrivateKey must be set.
+	PrivateKeyPath string `json:"private_key_path,omitempty"`
+
+	// PrivateKey is the signing key itself, in any form accepted by ParseSigningKey
+	PrivateKey string `json:"private_key,omitempty"`
+
+	// ValidationURL, RevokeURL, MigrationURL, AppleKeysURL and Issuer override Apple's endpoints, see ClientOptions
+	ValidationURL string `json:"validation_url,omitempty"`
+	RevokeURL     string `json:"revoke_url,omitempty"`
+	MigrationURL  string `json:"migration_url,omitempty"`
+	AppleKeysURL  string `json:"apple_keys_url,omitempty"`
+	Issuer        string `json:"issuer,omitempty"`
+
+	// JWKSCacheTTL is how long Apple's public keys are cached. Defaults to 15 minutes.
+	JWKSCacheTTL Duration `json:"jwks_cache_ttl,omitempty"`
+
+	// HTTPTimeout is the timeout of outbound requests to Apple. Defaults to 5 seconds.
+	HTTPTimeout Duration `json:"http_timeout,omitempty"`
+
+	// ClientSecretLifetime is the lifetime of generated client secrets. Defaults to DefaultClientSecretLifetime.
+	ClientSecretLifetime Duration `json:"client_secret_lifetime,omitempty"`
+
+	// RedirectURIs are the Return URLs registered for the Services IDs. They are only checked by ValidateConfig.
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+}
+
+// Environment variable names read by LoadConfigEnv, without their prefix.
+// CLIENT_IDS and REDIRECT_URIS are comma-separated lists; durations use Go syntax ("15m") or a number of seconds.
+const (
+	EnvTeamID               = "TEAM_ID"
+	EnvKeyID                = "KEY_ID"
+	EnvClientIDs            = "CLIENT_IDS"
+	EnvPrivateKeyPath       = "PRIVATE_KEY_PATH"
+	EnvPrivateKey           = "PRIVATE_KEY"
+	EnvValidationURL        = "VALIDATION_URL"
+	EnvRevokeURL            = "REVOKE_URL"
+	EnvMigrationURL         = "MIGRATION_URL"
+	EnvAppleKeysURL         = "APPLE_KEYS_URL"
+	EnvIssuer               = "ISSUER"
+	EnvJWKSCacheTTL         = "JWKS_CACHE_TTL"
+	EnvHTTPTimeout          = "HTTP_TIMEOUT"
+	EnvClientSecretLifetime = "CLIENT_SECRET_LIFETIME"
+	EnvRedirectURIs         = "REDIRECT_URIS"
+)
+
+// LoadConfigFile reads a JSON configuration file and validates it. Unknown fields are rejected so typos surface early.
+func LoadConfigFile(path string) (*Config, error) {
+	var cfg Config
+	if err := cfg.readFile(path); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// LoadConfigEnv reads the configuration from environment variables named prefix + one of the Env
+// constants, e.g. "APPLE_TEAM_ID" for the prefix "APPLE_", and validates it.
+func LoadConfigEnv(prefix string) (*Config, error) {
+	var cfg Config
+	if err := cfg.readEnv(prefix); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// LoadConfig reads the JSON file at path, when path is not empty, then applies any environment
+// variables with the given prefix on top of it and validates the result. Environment variables take
+// precedence, so a shared file can be committed while secrets such as the key come from the environment.
+func LoadConfig(path, prefix string) (*Config, error) {
+	var cfg Config
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.readEnv(prefix); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.TeamID == "" {
+		errs = append(errs, errors.New("team_id is required"))
+	}
+	if c.KeyID == "" {
+		errs = append(errs, errors.New("key_id is required"))
+	}
+	if len(c.ClientIDs) == 0 {
+		errs = append(errs, errors.New("at least one client ID is required"))
+	}
+	for i, id := range c.ClientIDs {
+		if strings.TrimSpace(id) == "" {
+			errs = append(errs, fmt.Errorf("client ID %d is empty", i))
+		}
+	}
+
+	switch {
+	case c.PrivateKeyPath == "" && c.PrivateKey == "":
+		errs = append(errs, errors.New("one of private_key_path and private_key is required"))
+	case c.PrivateKeyPath != "" && c.PrivateKey != "":
+		errs = append(errs, errors.New("only one of private_key_path and private_key may be set"))
+	}
+
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"validation_url", c.ValidationURL},
+		{"revoke_url", c.RevokeURL},
+		{"migration_url", c.MigrationURL},
+		{"apple_keys_url", c.AppleKeysURL},
+		{"issuer", c.Issuer},
+	} {
+		if field.value == "" {
+			continue
+		}
+		// Endpoints set by the operator may point at a local mock server
+		if err := validateEndpointURL(field.value, true); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field.name, err))
+		}
+	}
+
+	if c.JWKSCacheTTL < 0 {
+		errs = append(errs, errors.New("jwks_cache_ttl must not be negative"))
+	}
+	if c.HTTPTimeout < 0 {
+		errs = append(errs, errors.New("http_timeout must not be negative"))
+	}
+	if c.ClientSecretLifetime < 0 || time.Duration(c.ClientSecretLifetime) > MaxClientSecretLifetime {
+		errs = append(errs, fmt.Errorf("client_secret_lifetime must be between 0 and %s", MaxClientSecretLifetime))
+	}
+
+	return errors.Join(errs...)
+}
+
+// SigningKey loads and parses the configured private key.
+func (c *Config) SigningKey() (*ecdsa.PrivateKey, error) {
+	if c.PrivateKeyPath != "" {
+		return LoadSigningKeyFile(c.PrivateKeyPath)
+	}
+	return ParseSigningKey([]byte(c.PrivateKey))
+}
+
+// ClientOptions returns the ClientOptions described by the configuration, without a secret provider.
+func (c *Config) ClientOptions() ClientOptions {
+	opts := ClientOptions{
+		ValidationURL: c.ValidationURL,
+		RevokeURL:     c.RevokeURL,
+		MigrationURL:  c.MigrationURL,
+		AppleKeysURL:  c.AppleKeysURL,
+		Issuer:        c.Issuer,
+		JWKSCacheTTL:  time.Duration(c.JWKSCacheTTL),
+	}
+	if c.HTTPTimeout > 0 {
+		opts.Client = &http.Client{Timeout: time.Duration(c.HTTPTimeout)}
+	}
+	return opts
+}
+
+// SecretProvider loads the signing key and returns a RotatingSecretProvider signing with it.
+func (c *Config) SecretProvider() (*RotatingSecretProvider, error) {
+	key, err := c.SigningKey()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := NewSoftwareSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewRotatingSecretProvider(RotatingSecretProviderOptions{
+		TeamID:   c.TeamID,
+		Keys:     []SigningKey{{KeyID: c.KeyID, Signer: signer}},
+		Lifetime: time.Duration(c.ClientSecretLifetime),
+	})
+}
+
+// NewClient validates the configuration and builds a Client whose requests are signed by the returned
+// SecretProvider whenever their ClientSecret is left empty.
+func (c *Config) NewClient() (*Client, *RotatingSecretProvider, error) {
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	provider, err := c.SecretProvider()
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := c.ClientOptions()
+	opts.Secrets = provider
+	return NewWithOptions(opts), provider, nil
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) readEnv(prefix string) error {
+	strs := []struct {
+		name  string
+		field *string
+	}{
+		{EnvTeamID, &c.TeamID},
+		{EnvKeyID, &c.KeyID},
+		{EnvPrivateKeyPath, &c.PrivateKeyPath},
+		{EnvPrivateKey, &c.PrivateKey},
+		{EnvValidationURL, &c.ValidationURL},
+		{EnvRevokeURL, &c.RevokeURL},
+		{EnvMigrationURL, &c.MigrationURL},
+		{EnvAppleKeysURL, &c.AppleKeysURL},
+		{EnvIssuer, &c.Issuer},
+	}
+	for _, s := range strs {
+		if value, ok := lookupEnv(prefix + s.name); ok {
+			*s.field = value
+		}
+	}
+
+	// A key given in the environment replaces a key file from the config file and vice versa
+	if _, ok := lookupEnv(prefix + EnvPrivateKey); ok {
+		if _, ok := lookupEnv(prefix + EnvPrivateKeyPath); !ok {
+			c.PrivateKeyPath = ""
+		}
+	} else if _, ok := lookupEnv(prefix + EnvPrivateKeyPath); ok {
+		c.PrivateKey = ""
+	}
+
+	if value, ok := lookupEnv(prefix + EnvClientIDs); ok {
+		c.ClientIDs = splitEnvList(value)
+	}
+	if value, ok := lookupEnv(prefix + EnvRedirectURIs); ok {
+		c.RedirectURIs = splitEnvList(value)
+	}
+
+	var errs []error
+	for _, d := range []struct {
+		name  string
+		field *Duration
+	}{
+		{EnvJWKSCacheTTL, &c.JWKSCacheTTL},
+		{EnvHTTPTimeout, &c.HTTPTimeout},
+		{EnvClientSecretLifetime, &c.ClientSecretLifetime},
+	} {
+		value, ok := lookupEnv(prefix + d.name)
+		if !ok {
+			continue
+		}
+		parsed, err := parseConfigDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("$%s%s: %w", prefix, d.name, err))
+			continue
+		}
+		*d.field = parsed
+	}
+	return errors.Join(errs...)
+}
+
+// lookupEnv treats variables set to an empty string as unset
+func lookupEnv(name string) (string, bool) {
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return "", false
+	}
+	return value, true
+}
+
+// splitEnvList splits a comma-separated list, dropping empty entries
+func splitEnvList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseConfigDuration parses a Go duration string, or a bare number as seconds.
+func parseConfigDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: use Go syntax such as \"15m\" or a number of seconds", s)
+	}
+	return Duration(d), nil
+}
@@ -0,0 +1,152 @@
+package apple
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	keyPath := writeTestFile(t, "AuthKey_KEY0000001.p8", testSigningKey)
+	path := writeTestFile(t, "apple.json", `{
+		"team_id": "TEAM000001",
+		"key_id": "KEY0000001",
+		"client_ids": ["com.example.web", "com.example.app"],
+		"private_key_path": "`+keyPath+`",
+		"jwks_cache_ttl": "30m",
+		"http_timeout": 10,
+		"client_secret_lifetime": "720h"
+	}`)
+
+	cfg, err := LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"com.example.web", "com.example.app"}, cfg.ClientIDs)
+	assert.Equal(t, Duration(30*time.Minute), cfg.JWKSCacheTTL)
+	assert.Equal(t, Duration(10*time.Second), cfg.HTTPTimeout, "numbers are read as seconds")
+	assert.Equal(t, Duration(720*time.Hour), cfg.ClientSecretLifetime)
+
+	_, err = LoadConfigFile(writeTestFile(t, "typo.json", `{"team_idd": "TEAM000001"}`))
+	assert.ErrorContains(t, err, "team_idd", "unknown fields are rejected")
+
+	_, err = LoadConfigFile(writeTestFile(t, "bad.json", `{"jwks_cache_ttl": "soon"}`))
+	assert.ErrorContains(t, err, "soon")
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	t.Setenv("APPLE_TEAM_ID", "TEAM000001")
+	t.Setenv("APPLE_KEY_ID", "KEY0000001")
+	t.Setenv("APPLE_CLIENT_IDS", "com.example.web, com.example.app,")
+	t.Setenv("APPLE_PRIVATE_KEY", testSigningKey)
+	t.Setenv("APPLE_VALIDATION_URL", "https://mock.test/auth/token")
+	t.Setenv("APPLE_JWKS_CACHE_TTL", "1h")
+
+	cfg, err := LoadConfigEnv("APPLE_")
+	require.NoError(t, err)
+	assert.Equal(t, "TEAM000001", cfg.TeamID)
+	assert.Equal(t, []string{"com.example.web", "com.example.app"}, cfg.ClientIDs)
+	assert.Equal(t, "https://mock.test/auth/token", cfg.ValidationURL)
+	assert.Equal(t, Duration(time.Hour), cfg.JWKSCacheTTL)
+
+	t.Setenv("APPLE_HTTP_TIMEOUT", "later")
+	_, err = LoadConfigEnv("APPLE_")
+	assert.ErrorContains(t, err, "$APPLE_HTTP_TIMEOUT")
+}
+
+func TestLoadConfigEnvOverridesFile(t *testing.T) {
+	keyPath := writeTestFile(t, "AuthKey.p8", testSigningKey)
+	path := writeTestFile(t, "apple.json", `{
+		"team_id": "TEAM000001",
+		"key_id": "KEY0000001",
+		"client_ids": ["com.example.web"],
+		"private_key_path": "`+keyPath+`"
+	}`)
+
+	t.Setenv("SIWA_KEY_ID", "KEY0000002")
+	t.Setenv("SIWA_PRIVATE_KEY", testSigningKey)
+
+	cfg, err := LoadConfig(path, "SIWA_")
+	require.NoError(t, err)
+	assert.Equal(t, "TEAM000001", cfg.TeamID)
+	assert.Equal(t, "KEY0000002", cfg.KeyID)
+	assert.Empty(t, cfg.PrivateKeyPath, "a key from the environment replaces the key file")
+	assert.Equal(t, testSigningKey, cfg.PrivateKey)
+}
+
+func TestConfigValidate(t *testing.T) {
+	err := (&Config{}).Validate()
+	require.Error(t, err)
+	for _, want := range []string{"team_id", "key_id", "client ID", "private_key"} {
+		assert.ErrorContains(t, err, want)
+	}
+
+	cfg := Config{
+		TeamID:               "TEAM000001",
+		KeyID:                "KEY0000001",
+		ClientIDs:            []string{"com.example.web", " "},
+		PrivateKey:           testSigningKey,
+		PrivateKeyPath:       "AuthKey.p8",
+		RevokeURL:            "/auth/revoke",
+		ClientSecretLifetime: Duration(365 * 24 * time.Hour),
+	}
+	err = cfg.Validate()
+	require.Error(t, err)
+	for _, want := range []string{"client ID 1 is empty", "only one of", "revoke_url", "client_secret_lifetime"} {
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestConfigNewClient(t *testing.T) {
+	cfg := Config{
+		TeamID:       "TEAM000001",
+		KeyID:        "KEY0000001",
+		ClientIDs:    []string{"com.example.web"},
+		PrivateKey:   testSigningKey,
+		RevokeURL:    "https://mock.test/auth/revoke",
+		JWKSCacheTTL: Duration(time.Hour),
+		HTTPTimeout:  Duration(2 * time.Second),
+	}
+
+	client, provider, err := cfg.NewClient()
+	require.NoError(t, err)
+
+	opts := client.Options()
+	assert.Equal(t, "https://mock.test/auth/revoke", opts.RevokeURL)
+	assert.Equal(t, ValidationURL, opts.ValidationURL)
+	assert.Equal(t, time.Hour, opts.JWKSCacheTTL)
+	assert.Same(t, provider, opts.Secrets)
+
+	secret, err := provider.ClientSecret(context.Background(), "com.example.web")
+	require.NoError(t, err)
+	info, err := InspectClientSecret(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "TEAM000001", info.TeamID)
+	assert.Equal(t, "KEY0000001", info.KeyID)
+
+	cfg.PrivateKey = "not a key"
+	_, _, err = cfg.NewClient()
+	assert.ErrorIs(t, err, ErrInvalidSigningKey)
+}
+
+func TestDurationJSON(t *testing.T) {
+	data, err := json.Marshal(Duration(90 * time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, `"1m30s"`, string(data))
+
+	var d Duration
+	require.NoError(t, json.Unmarshal([]byte(`1.5`), &d))
+	assert.Equal(t, Duration(1500*time.Millisecond), d)
+	assert.Error(t, json.Unmarshal([]byte(`true`), &d))
+}
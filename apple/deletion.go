@@ -0,0 +1,451 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AccountDeletionDeadline is how long Apple allows for deleting a user's data after an account-delete
+// notification. See https://developer.apple.com/documentation/technotes/tn3194-handling-account-deletions-and-revoking-tokens-for-sign-in-with-apple
+const AccountDeletionDeadline = 30 * 24 * time.Hour
+
+// ErrDeletionNotFound is returned by DeletionStore.Load when no deletion was started for the subject
+var ErrDeletionNotFound = errors.New("account deletion not found")
+
+// Sources of an account deletion, as recorded in DeletionRecord.Source
+const (
+	// DeletionSourceNotification is an account-delete server notification from Apple
+	DeletionSourceNotification = "account-delete"
+
+	// DeletionSourceUser is a deletion requested by the user in the app
+	DeletionSourceUser = "user"
+)
+
+// DeletionRecord tracks the deletion of one user's account. Steps that succeeded are not repeated on retry.
+type DeletionRecord struct {
+	// Subject is the user's stable identifier
+	Subject string
+
+	// Source is DeletionSourceNotification or DeletionSourceUser
+	Source string
+
+	// RequestedAt is when the deletion was requested: the notification's event time or the user's request
+	RequestedAt time.Time
+
+	// Deadline is RequestedAt plus AccountDeletionDeadline
+	Deadline time.Time
+
+	// TokensRevoked is set once every stored token of the user was revoked and deleted
+	TokensRevoked bool
+
+	// CompletedHooks are the names of the data-deletion hooks that succeeded
+	CompletedHooks []string
+
+	// Attempts is the number of failed attempts so far
+	Attempts int
+
+	// LastError describes the most recent failure
+	LastError string
+
+	// NextAttempt is when ProcessPending retries the deletion
+	NextAttempt time.Time
+
+	// CompletedAt is when every step succeeded. It is zero while the deletion is pending.
+	CompletedAt time.Time
+}
+
+// Completed reports whether every step of the deletion succeeded
+func (r *DeletionRecord) Completed() bool {
+	return !r.CompletedAt.IsZero()
+}
+
+// hookCompleted reports whether the hook called name already succeeded
+func (r *DeletionRecord) hookCompleted(name string) bool {
+	for _, completed := range r.CompletedHooks {
+		if completed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DeletionStore persists the progress of account deletions so they survive restarts
+type DeletionStore interface {
+	// Save stores record, replacing any record of the same subject
+	Save(ctx context.Context, record DeletionRecord) error
+
+	// Load returns the record of subject, or ErrDeletionNotFound
+	Load(ctx context.Context, subject string) (*DeletionRecord, error)
+
+	// Pending returns every record that is not completed
+	Pending(ctx context.Context) ([]DeletionRecord, error)
+}
+
+// MemoryDeletionStore is an in-memory DeletionStore for tests. Progress is lost on restart.
+type MemoryDeletionStore struct {
+	mu      sync.Mutex
+	records map[string]DeletionRecord
+}
+
+// NewMemoryDeletionStore creates an empty MemoryDeletionStore
+func NewMemoryDeletionStore() *MemoryDeletionStore {
+	return &MemoryDeletionStore{records: make(map[string]DeletionRecord)}
+}
+
+// Save stores record
+func (m *MemoryDeletionStore) Save(ctx context.Context, record DeletionRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record.CompletedHooks = append([]string(nil), record.CompletedHooks...)
+	m.records[record.Subject] = record
+	return nil
+}
+
+// Load returns the record of subject
+func (m *MemoryDeletionStore) Load(ctx context.Context, subject string) (*DeletionRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.records[subject]
+	if !ok {
+		return nil, ErrDeletionNotFound
+	}
+	record.CompletedHooks = append([]string(nil), record.CompletedHooks...)
+	return &record, nil
+}
+
+// Pending returns the records that are not completed, ordered by deadline
+func (m *MemoryDeletionStore) Pending(ctx context.Context) ([]DeletionRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pending []DeletionRecord
+	for _, record := range m.records {
+		if !record.Completed() {
+			record.CompletedHooks = append([]string(nil), record.CompletedHooks...)
+			pending = append(pending, record)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Deadline.Before(pending[j].Deadline) })
+	return pending, nil
+}
+
+// DeletionOrchestratorOptions configures a DeletionOrchestrator
+type DeletionOrchestratorOptions struct {
+	// Tokens holds the users' refresh tokens, which are revoked and then deleted
+	Tokens TokenStore
+
+	// Client revokes the tokens, usually a *Client
+	Client TokenRevoker
+
+	// Secrets supplies client secrets. Leave it nil when Client already has ClientOptions.Secrets.
+	Secrets SecretProvider
+
+	// Progress records each deletion's progress. Defaults to a MemoryDeletionStore, which does not
+	// survive restarts; use a persistent store in production.
+	Progress DeletionStore
+
+	// Clock supplies the current time. Defaults to SystemClock.
+	Clock Clock
+
+	// RetryBackoff is the delay before the first retry of a failed deletion. It doubles with each
+	// attempt up to MaxRetryBackoff. Defaults to one minute.
+	RetryBackoff time.Duration
+
+	// MaxRetryBackoff caps the retry delay. Defaults to one hour.
+	MaxRetryBackoff time.Duration
+
+	// OnError is called with problems that do not stop a deletion: a stored token that could not be
+	// decrypted, reported as a *TokenDecryptionError, is deleted without being revoked. Revoke it by
+	// other means if its key can be recovered. Optional.
+	OnError func(subject string, err error)
+}
+
+type deletionHook struct {
+	name string
+	fn   func(ctx context.Context, subject string) error
+}
+
+// DeletionOrchestrator carries out account deletions: it revokes the user's stored tokens with Apple,
+// runs the registered data-deletion hooks and records progress so failed steps are retried until the
+// deletion completes. It is safe for concurrent use.
+type DeletionOrchestrator struct {
+	tokens       TokenStore
+	client       TokenRevoker
+	secrets      SecretProvider
+	progress     DeletionStore
+	clock        Clock
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+	onError      func(subject string, err error)
+
+	mu      sync.Mutex
+	hooks   []deletionHook
+	running map[string]bool
+}
+
+// NewDeletionOrchestrator creates a DeletionOrchestrator. Tokens and Client are required.
+func NewDeletionOrchestrator(options DeletionOrchestratorOptions) (*DeletionOrchestrator, error) {
+	if options.Tokens == nil || options.Client == nil {
+		return nil, errors.New("token store and client are required")
+	}
+	if options.Progress == nil {
+		options.Progress = NewMemoryDeletionStore()
+	}
+	if options.RetryBackoff == 0 {
+		options.RetryBackoff = time.Minute
+	}
+	if options.MaxRetryBackoff == 0 {
+		options.MaxRetryBackoff = time.Hour
+	}
+
+	return &DeletionOrchestrator{
+		tokens:       options.Tokens,
+		client:       options.Client,
+		secrets:      options.Secrets,
+		progress:     options.Progress,
+		clock:        clockOrDefault(options.Clock),
+		retryBackoff: options.RetryBackoff,
+		maxBackoff:   options.MaxRetryBackoff,
+		onError:      options.OnError,
+		running:      make(map[string]bool),
+	}, nil
+}
+
+// OnDelete registers a hook that deletes the user's data from one system, e.g. "profiles" or "uploads".
+// Hooks run in registration order after the tokens are revoked. A hook that fails stops the attempt and
+// is retried later, while hooks that already succeeded are not run again, so each hook must be
+// idempotent. Names must be unique and stable across restarts, as progress is recorded by name;
+// OnDelete panics on a name that is already registered.
+func (o *DeletionOrchestrator) OnDelete(name string, fn func(ctx context.Context, subject string) error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, hook := range o.hooks {
+		if hook.name == name {
+			panic(fmt.Sprintf("apple.DeletionOrchestrator: deletion hook %q is already registered", name))
+		}
+	}
+	o.hooks = append(o.hooks, deletionHook{name: name, fn: fn})
+}
+
+// HandleNotification starts the deletion requested by an account-delete notification, as parsed by
+// ParseServerNotification, and makes a first attempt. Other event types are ignored and return a nil
+// record. A redelivered notification, with the event time of a completed deletion, returns its record.
+func (o *DeletionOrchestrator) HandleNotification(ctx context.Context, claims *ServerNotificationClaims) (*DeletionRecord, error) {
+	if claims == nil || claims.Events.Type != NotificationTypeAccountDelete {
+		return nil, nil
+	}
+	if claims.Events.Sub == "" {
+		return nil, errors.New("account-delete notification has no subject")
+	}
+	requestedAt := notificationTime(claims.Events.EventTime)
+	if requestedAt.IsZero() {
+		requestedAt = o.clock.Now()
+	}
+	return o.start(ctx, claims.Events.Sub, DeletionSourceNotification, requestedAt)
+}
+
+// RequestDeletion starts the deletion of subject's account at the user's request and makes a first attempt
+func (o *DeletionOrchestrator) RequestDeletion(ctx context.Context, subject string) (*DeletionRecord, error) {
+	if subject == "" {
+		return nil, errors.New("subject is required")
+	}
+	return o.start(ctx, subject, DeletionSourceUser, o.clock.Now())
+}
+
+// ProcessPending retries every pending deletion whose NextAttempt has passed. Call it periodically.
+// Failures are recorded on the deletions and returned joined together.
+func (o *DeletionOrchestrator) ProcessPending(ctx context.Context) error {
+	pending, err := o.progress.Pending(ctx)
+	if err != nil {
+		return fmt.Errorf("listing pending deletions: %w", err)
+	}
+
+	now := o.clock.Now()
+	var errs []error
+	for i := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if pending[i].NextAttempt.After(now) {
+			continue
+		}
+		if _, err := o.process(ctx, &pending[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Approaching returns the pending deletions whose deadline is less than within away, including overdue
+// ones, ordered by deadline. Alert on these before Apple's 30 days run out.
+func (o *DeletionOrchestrator) Approaching(ctx context.Context, within time.Duration) ([]DeletionRecord, error) {
+	pending, err := o.progress.Pending(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing pending deletions: %w", err)
+	}
+
+	cutoff := o.clock.Now().Add(within)
+	var approaching []DeletionRecord
+	for _, record := range pending {
+		if record.Deadline.Before(cutoff) {
+			approaching = append(approaching, record)
+		}
+	}
+	sort.Slice(approaching, func(i, j int) bool { return approaching[i].Deadline.Before(approaching[j].Deadline) })
+	return approaching, nil
+}
+
+// start records a new deletion of subject and processes it. A pending deletion of subject is processed
+// instead, and a completed one is returned as is if it was requested at requestedAt, i.e. the request is
+// a redelivery. A user who signs up again after a completed deletion keeps the same subject, so any
+// other request starts over.
+func (o *DeletionOrchestrator) start(ctx context.Context, subject, source string, requestedAt time.Time) (*DeletionRecord, error) {
+	record, err := o.progress.Load(ctx, subject)
+	switch {
+	case err == nil && !record.Completed():
+		return o.process(ctx, record)
+	case err == nil && record.RequestedAt.Equal(requestedAt):
+		return record, nil
+	case err != nil && !errors.Is(err, ErrDeletionNotFound):
+		return nil, fmt.Errorf("loading deletion of %s: %w", subject, err)
+	}
+
+	record = &DeletionRecord{
+		Subject:     subject,
+		Source:      source,
+		RequestedAt: requestedAt,
+		Deadline:    requestedAt.Add(AccountDeletionDeadline),
+	}
+	if err := o.progress.Save(ctx, *record); err != nil {
+		return nil, fmt.Errorf("saving deletion of %s: %w", subject, err)
+	}
+	return o.process(ctx, record)
+}
+
+// process runs the remaining steps of record and saves its progress. A deletion already being
+// processed by another goroutine is returned as is.
+func (o *DeletionOrchestrator) process(ctx context.Context, record *DeletionRecord) (*DeletionRecord, error) {
+	o.mu.Lock()
+	if o.running[record.Subject] {
+		o.mu.Unlock()
+		return record, nil
+	}
+	o.running[record.Subject] = true
+	hooks := append([]deletionHook(nil), o.hooks...)
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		delete(o.running, record.Subject)
+		o.mu.Unlock()
+	}()
+
+	stepErr := o.runSteps(ctx, record, hooks)
+	now := o.clock.Now()
+	if stepErr == nil {
+		record.CompletedAt = now
+		record.LastError = ""
+		record.NextAttempt = time.Time{}
+	} else {
+		record.Attempts++
+		record.LastError = stepErr.Error()
+		record.NextAttempt = now.Add(o.backoff(record.Attempts))
+		stepErr = fmt.Errorf("deleting account %s: %w", record.Subject, stepErr)
+	}
+
+	if err := o.progress.Save(ctx, *record); err != nil {
+		return record, errors.Join(stepErr, fmt.Errorf("saving deletion of %s: %w", record.Subject, err))
+	}
+	return record, stepErr
+}
+
+// runSteps revokes the user's tokens and runs the hooks that have not succeeded yet, stopping at the first failure
+func (o *DeletionOrchestrator) runSteps(ctx context.Context, record *DeletionRecord, hooks []deletionHook) error {
+	if !record.TokensRevoked {
+		if err := o.revokeTokens(ctx, record.Subject); err != nil {
+			return err
+		}
+		record.TokensRevoked = true
+	}
+
+	for _, hook := range hooks {
+		if record.hookCompleted(hook.name) {
+			continue
+		}
+		if err := hook.fn(ctx, record.Subject); err != nil {
+			return fmt.Errorf("hook %s: %w", hook.name, err)
+		}
+		record.CompletedHooks = append(record.CompletedHooks, hook.name)
+	}
+	return nil
+}
+
+// revokeTokens revokes and deletes every stored token of subject. Tokens that cannot be decrypted
+// cannot be revoked either; they are deleted and reported to OnError so the deletion can complete.
+func (o *DeletionOrchestrator) revokeTokens(ctx context.Context, subject string) error {
+	tokens, err := o.tokens.ListSubject(ctx, subject)
+	undecryptable, err := splitDecryptionErrors(err)
+	if err != nil {
+		return fmt.Errorf("listing tokens: %w", err)
+	}
+
+	var errs []error
+	for _, decryptionErr := range undecryptable {
+		if o.onError != nil {
+			o.onError(subject, decryptionErr)
+		}
+		if err := o.tokens.Delete(ctx, subject, decryptionErr.ClientID); err != nil {
+			errs = append(errs, fmt.Errorf("deleting tokens for %s: %w", decryptionErr.ClientID, err))
+		}
+	}
+	for _, token := range tokens {
+		if err := o.revoke(ctx, token); err != nil {
+			errs = append(errs, fmt.Errorf("revoking tokens for %s: %w", token.ClientID, err))
+			continue
+		}
+		if err := o.tokens.Delete(ctx, subject, token.ClientID); err != nil {
+			errs = append(errs, fmt.Errorf("deleting tokens for %s: %w", token.ClientID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// revoke revokes the refresh and access tokens of token with RevokeUser
+func (o *DeletionOrchestrator) revoke(ctx context.Context, token StoredToken) error {
+	req := RevokeUserRequest{ClientID: token.ClientID, RefreshToken: token.RefreshToken, AccessToken: token.AccessToken}
+	if o.secrets != nil {
+		secret, err := o.secrets.ClientSecret(ctx, token.ClientID)
+		if err != nil {
+			return err
+		}
+		req.ClientSecret = secret
+	}
+	return RevokeUser(ctx, o.client, req).Err()
+}
+
+// backoff returns the delay before retrying a deletion that failed attempts times
+func (o *DeletionOrchestrator) backoff(attempts int) time.Duration {
+	delay := o.retryBackoff
+	for i := 1; i < attempts && delay < o.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > o.maxBackoff {
+		delay = o.maxBackoff
+	}
+	return delay
+}
+
+// notificationTime converts a notification's event_time, which Apple sends in milliseconds, to a time.
+// Values small enough to be seconds are read as seconds.
+func notificationTime(eventTime int64) time.Time {
+	switch {
+	case eventTime <= 0:
+		return time.Time{}
+	case eventTime < 1e11:
+		return time.Unix(eventTime, 0)
+	default:
+		return time.UnixMilli(eventTime)
+	}
+}
@@ -0,0 +1,241 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDeletionOrchestrator(t *testing.T, fake *FakeClient, clock Clock) (*DeletionOrchestrator, *MemoryTokenStore) {
+	t.Helper()
+	tokens := NewMemoryTokenStore()
+	ctx := context.Background()
+	require.NoError(t, tokens.Put(ctx, StoredToken{Subject: "user1", ClientID: "com.example.app", RefreshToken: "r1", AccessToken: "a1"}))
+	require.NoError(t, tokens.Put(ctx, StoredToken{Subject: "user1", ClientID: "com.example.web", RefreshToken: "r2"}))
+	require.NoError(t, tokens.Put(ctx, StoredToken{Subject: "user2", ClientID: "com.example.app", RefreshToken: "r3"}))
+
+	o, err := NewDeletionOrchestrator(DeletionOrchestratorOptions{Tokens: tokens, Client: fake, Clock: clock})
+	require.NoError(t, err)
+	return o, tokens
+}
+
+func TestDeletionOrchestratorNotification(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	fake := NewFakeClient().
+		Respond("RevokeRefreshToken", nil, nil).
+		Respond("RevokeAccessToken", `{"error":"invalid_grant"}`, nil)
+	o, tokens := newTestDeletionOrchestrator(t, fake, clock)
+
+	var deleted []string
+	o.OnDelete("profiles", func(ctx context.Context, subject string) error {
+		deleted = append(deleted, "profiles:"+subject)
+		return nil
+	})
+	o.OnDelete("uploads", func(ctx context.Context, subject string) error {
+		deleted = append(deleted, "uploads:"+subject)
+		return nil
+	})
+
+	eventTime := time.Unix(1699990000, 0)
+	claims := &ServerNotificationClaims{Events: ServerNotificationPayload{Type: "account-delete", Sub: "user1", EventTime: eventTime.UnixMilli()}}
+	record, err := o.HandleNotification(ctx, claims)
+	require.NoError(t, err)
+	assert.True(t, record.Completed())
+	assert.Equal(t, DeletionSourceNotification, record.Source)
+	assert.Equal(t, eventTime, record.RequestedAt)
+	assert.Equal(t, eventTime.Add(AccountDeletionDeadline), record.Deadline)
+	assert.Equal(t, []string{"profiles:user1", "uploads:user1"}, deleted)
+
+	revoked := fake.CallsTo("RevokeRefreshToken")
+	require.Len(t, revoked, 2)
+	assert.Equal(t, RevokeRefreshTokenRequest{ClientID: "com.example.app", RefreshToken: "r1"}, revoked[0].Request)
+	require.Len(t, fake.CallsTo("RevokeAccessToken"), 1, "an already invalid access token counts as revoked")
+
+	remaining, err := tokens.ListSubject(ctx, "user1")
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+	_, err = tokens.Get(ctx, "user2", "com.example.app")
+	assert.NoError(t, err, "other users' tokens are untouched")
+
+	again, err := o.HandleNotification(ctx, claims)
+	require.NoError(t, err)
+	assert.Equal(t, record.CompletedAt, again.CompletedAt)
+	assert.Len(t, deleted, 2, "a redelivered notification does not run the hooks again")
+
+	ignored, err := o.HandleNotification(ctx, &ServerNotificationClaims{Events: ServerNotificationPayload{Type: "consent-revoked", Sub: "user2"}})
+	require.NoError(t, err)
+	assert.Nil(t, ignored)
+}
+
+func TestDeletionOrchestratorUndecryptableTokens(t *testing.T) {
+	ctx := context.Background()
+	backing := NewMemoryTokenStore()
+	tokens := NewEncryptedTokenStore(backing, newTestKEK(t))
+	require.NoError(t, backing.Put(ctx, StoredToken{Subject: "user1", ClientID: "com.example.app", RefreshToken: "v1.corrupt.record"}))
+	require.NoError(t, tokens.Put(ctx, StoredToken{Subject: "user1", ClientID: "com.example.web", RefreshToken: "r2"}))
+
+	fake := NewFakeClient().Respond("RevokeRefreshToken", nil, nil)
+	var reported []error
+	o, err := NewDeletionOrchestrator(DeletionOrchestratorOptions{
+		Tokens:  tokens,
+		Client:  fake,
+		OnError: func(subject string, err error) { reported = append(reported, err) },
+	})
+	require.NoError(t, err)
+
+	record, err := o.RequestDeletion(ctx, "user1")
+	require.NoError(t, err)
+	assert.True(t, record.Completed(), "a token that cannot be decrypted does not block the deletion")
+
+	revoked := fake.CallsTo("RevokeRefreshToken")
+	require.Len(t, revoked, 1)
+	assert.Equal(t, RevokeRefreshTokenRequest{ClientID: "com.example.web", RefreshToken: "r2"}, revoked[0].Request)
+	require.Len(t, reported, 1)
+	var decryptionErr *TokenDecryptionError
+	require.ErrorAs(t, reported[0], &decryptionErr)
+	assert.Equal(t, "com.example.app", decryptionErr.ClientID)
+
+	remaining, err := backing.ListSubject(ctx, "user1")
+	require.NoError(t, err)
+	assert.Empty(t, remaining, "the undecryptable record is deleted too")
+}
+
+func TestDeletionOrchestratorRepeatedDeletion(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	fake := NewFakeClient().
+		Respond("RevokeRefreshToken", nil, nil).
+		Respond("RevokeAccessToken", nil, nil)
+	o, tokens := newTestDeletionOrchestrator(t, fake, clock)
+	var deleted int
+	o.OnDelete("profiles", func(ctx context.Context, subject string) error {
+		deleted++
+		return nil
+	})
+
+	first, err := o.RequestDeletion(ctx, "user1")
+	require.NoError(t, err)
+	assert.True(t, first.Completed())
+	assert.Equal(t, 1, deleted)
+
+	// The user signs up again with the same subject, then deletes their account again
+	clock.Advance(24 * time.Hour)
+	require.NoError(t, tokens.Put(ctx, StoredToken{Subject: "user1", ClientID: "com.example.app", RefreshToken: "r4"}))
+	eventTime := clock.Now().Add(-time.Minute)
+	claims := &ServerNotificationClaims{Events: ServerNotificationPayload{Type: "account-delete", Sub: "user1", EventTime: eventTime.UnixMilli()}}
+	second, err := o.HandleNotification(ctx, claims)
+	require.NoError(t, err)
+	assert.True(t, second.Completed())
+	assert.Equal(t, eventTime, second.RequestedAt)
+	assert.Equal(t, DeletionSourceNotification, second.Source)
+	assert.Equal(t, 2, deleted, "a completed deletion does not stop a later one")
+	revoked := fake.CallsTo("RevokeRefreshToken")
+	require.Len(t, revoked, 3)
+	assert.Equal(t, RevokeRefreshTokenRequest{ClientID: "com.example.app", RefreshToken: "r4"}, revoked[2].Request)
+	remaining, err := tokens.ListSubject(ctx, "user1")
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+
+	clock.Advance(time.Hour)
+	again, err := o.HandleNotification(ctx, claims)
+	require.NoError(t, err)
+	assert.Equal(t, second.CompletedAt, again.CompletedAt)
+	assert.Equal(t, 2, deleted, "a redelivered notification does not run the hooks again")
+}
+
+func TestDeletionOrchestratorRetries(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	fake := NewFakeClient().
+		Respond("RevokeRefreshToken", nil, nil).
+		Respond("RevokeAccessToken", nil, nil)
+	o, _ := newTestDeletionOrchestrator(t, fake, clock)
+
+	profileCalls, uploadFailures := 0, 2
+	o.OnDelete("profiles", func(ctx context.Context, subject string) error {
+		profileCalls++
+		return nil
+	})
+	o.OnDelete("uploads", func(ctx context.Context, subject string) error {
+		if uploadFailures > 0 {
+			uploadFailures--
+			return errors.New("storage unavailable")
+		}
+		return nil
+	})
+
+	record, err := o.RequestDeletion(ctx, "user1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "hook uploads: storage unavailable")
+	assert.False(t, record.Completed())
+	assert.True(t, record.TokensRevoked)
+	assert.Equal(t, []string{"profiles"}, record.CompletedHooks)
+	assert.Equal(t, 1, record.Attempts)
+	assert.Equal(t, clock.Now().Add(time.Minute), record.NextAttempt)
+
+	require.NoError(t, o.ProcessPending(ctx), "the retry is not due yet")
+	clock.Advance(time.Minute)
+	assert.Error(t, o.ProcessPending(ctx))
+
+	clock.Advance(2 * time.Minute)
+	require.NoError(t, o.ProcessPending(ctx))
+	assert.Equal(t, 1, profileCalls, "completed hooks are not repeated")
+	assert.Len(t, fake.CallsTo("RevokeRefreshToken"), 2, "tokens are revoked once")
+
+	approaching, err := o.Approaching(ctx, AccountDeletionDeadline)
+	require.NoError(t, err)
+	assert.Empty(t, approaching)
+}
+
+func TestDeletionOrchestratorApproaching(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	fake := NewFakeClient().Respond("RevokeRefreshToken", `{"error":"invalid_client"}`, nil)
+	o, _ := newTestDeletionOrchestrator(t, fake, clock)
+
+	_, err := o.RequestDeletion(ctx, "user1")
+	require.Error(t, err)
+	clock.Advance(10 * 24 * time.Hour)
+	_, err = o.RequestDeletion(ctx, "user2")
+	require.Error(t, err)
+
+	clock.Advance(15 * 24 * time.Hour)
+	approaching, err := o.Approaching(ctx, 7*24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, approaching, 1)
+	assert.Equal(t, "user1", approaching[0].Subject)
+	assert.Contains(t, approaching[0].LastError, "invalid_client")
+
+	approaching, err = o.Approaching(ctx, 30*24*time.Hour)
+	require.NoError(t, err)
+	assert.Len(t, approaching, 2)
+}
+
+func TestDeletionOrchestratorDuplicateHook(t *testing.T) {
+	o, _ := newTestDeletionOrchestrator(t, NewFakeClient(), nil)
+	hook := func(ctx context.Context, subject string) error { return nil }
+	o.OnDelete("profiles", hook)
+	assert.Panics(t, func() { o.OnDelete("profiles", hook) }, "hooks sharing a name would share their progress")
+}
+
+func TestDeletionOrchestratorBackoff(t *testing.T) {
+	o, err := NewDeletionOrchestrator(DeletionOrchestratorOptions{Tokens: NewMemoryTokenStore(), Client: NewFakeClient()})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, o.backoff(1))
+	assert.Equal(t, 4*time.Minute, o.backoff(3))
+	assert.Equal(t, time.Hour, o.backoff(20))
+
+	_, err = NewDeletionOrchestrator(DeletionOrchestratorOptions{Client: NewFakeClient()})
+	assert.Error(t, err)
+}
+
+func TestNotificationTime(t *testing.T) {
+	assert.True(t, notificationTime(0).IsZero())
+	assert.Equal(t, time.Unix(1700000000, 0), notificationTime(1700000000))
+	assert.Equal(t, time.UnixMilli(1700000000123), notificationTime(1700000000123))
+}
@@ -0,0 +1,229 @@
+package apple
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DiscoveryURL is Apple's OpenID Connect discovery document.
+	DiscoveryURL string = "https://appleid.apple.com/.well-known/openid-configuration"
+)
+
+// supportedSigningAlgorithms lists the id_token signing algorithms VerifyIDToken can verify.
+var supportedSigningAlgorithms = []string{"RS256"}
+
+// supportedResponseModes lists the authorization response modes the token endpoints can be used with.
+var supportedResponseModes = []string{"query", "fragment", "form_post"}
+
+// DiscoveryDocument is the subset of an OpenID Connect discovery document used to configure a Client.
+// See https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// discoveryPath is the path OpenID Connect Discovery appends to an issuer to locate its document
+const discoveryPath = "/.well-known/openid-configuration"
+
+// ParseDiscoveryDocument decodes and validates a discovery document.
+func ParseDiscoveryDocument(data []byte) (*DiscoveryDocument, error) {
+	return parseDiscoveryDocument(data, false)
+}
+
+func parseDiscoveryDocument(data []byte, allowInsecure bool) (*DiscoveryDocument, error) {
+	var doc DiscoveryDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+	if err := doc.validate(allowInsecure); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// FetchDiscoveryDocument downloads and validates the discovery document at discoveryURL.
+// An empty discoveryURL fetches Apple's document and a nil client uses a default http.Client.
+//
+// As OpenID Connect Discovery section 4.3 requires, discoveryURL must be the document's issuer
+// followed by /.well-known/openid-configuration, so a document cannot claim another issuer.
+func FetchDiscoveryDocument(ctx context.Context, client HTTPClient, discoveryURL string) (*DiscoveryDocument, error) {
+	return fetchDiscoveryDocument(ctx, client, discoveryURL, false)
+}
+
+func fetchDiscoveryDocument(ctx context.Context, client HTTPClient, discoveryURL string, allowInsecure bool) (*DiscoveryDocument, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	if discoveryURL == "" {
+		discoveryURL = DiscoveryURL
+	}
+	issuer, ok := strings.CutSuffix(discoveryURL, discoveryPath)
+	if !ok {
+		return nil, fmt.Errorf("discovery URL %q does not end with %s", discoveryURL, discoveryPath)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("accept", AcceptHeader)
+	req.Header.Add("user-agent", UserAgent)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery endpoint returned HTTP %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read discovery document: %w", err)
+	}
+	doc, err := parseDiscoveryDocument(body, allowInsecure)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q, the issuer it was fetched for", doc.Issuer, issuer)
+	}
+	return doc, nil
+}
+
+// Validate checks that the document advertises the https endpoints a Client needs and that its
+// signing algorithms, response modes and client authentication methods are ones this
+// package supports. All problems are reported together.
+func (d *DiscoveryDocument) Validate() error {
+	return d.validate(false)
+}
+
+// validate is Validate, accepting http:// endpoints when allowInsecure is set
+func (d *DiscoveryDocument) validate(allowInsecure bool) error {
+	var errs []error
+
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"issuer", d.Issuer},
+		{"token_endpoint", d.TokenEndpoint},
+		{"jwks_uri", d.JWKSURI},
+	} {
+		if field.value == "" {
+			errs = append(errs, fmt.Errorf("discovery document is missing %s", field.name))
+			continue
+		}
+		if err := validateEndpointURL(field.value, allowInsecure); err != nil {
+			errs = append(errs, fmt.Errorf("discovery document %s: %w", field.name, err))
+		}
+	}
+	if d.RevocationEndpoint != "" {
+		if err := validateEndpointURL(d.RevocationEndpoint, allowInsecure); err != nil {
+			errs = append(errs, fmt.Errorf("discovery document revocation_endpoint: %w", err))
+		}
+	}
+
+	if !containsAny(d.IDTokenSigningAlgValuesSupported, supportedSigningAlgorithms) {
+		errs = append(errs, fmt.Errorf("discovery document advertises id_token signing algorithms %v, supported: %v",
+			d.IDTokenSigningAlgValuesSupported, supportedSigningAlgorithms))
+	}
+	if len(d.ResponseModesSupported) > 0 && !containsAny(d.ResponseModesSupported, supportedResponseModes) {
+		errs = append(errs, fmt.Errorf("discovery document advertises response modes %v, supported: %v",
+			d.ResponseModesSupported, supportedResponseModes))
+	}
+	// The token and revoke requests send the client secret in the form body
+	if len(d.TokenEndpointAuthMethodsSupported) > 0 && !containsAny(d.TokenEndpointAuthMethodsSupported, []string{"client_secret_post"}) {
+		errs = append(errs, fmt.Errorf("discovery document does not support client_secret_post authentication"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ApplyTo returns a copy of options with the endpoints and issuer from the document filled in.
+// Values already set in options take precedence over the document.
+func (d *DiscoveryDocument) ApplyTo(options ClientOptions) ClientOptions {
+	if options.ValidationURL == "" {
+		options.ValidationURL = d.TokenEndpoint
+	}
+	if options.RevokeURL == "" {
+		options.RevokeURL = d.RevocationEndpoint
+	}
+	if options.AppleKeysURL == "" {
+		options.AppleKeysURL = d.JWKSURI
+	}
+	if options.Issuer == "" {
+		options.Issuer = d.Issuer
+	}
+	return options
+}
+
+// NewWithDiscovery fetches the discovery document at discoveryURL (Apple's when empty) and creates
+// a Client configured from it. The fetch uses options.Client when set.
+func NewWithDiscovery(ctx context.Context, discoveryURL string, options ClientOptions) (*Client, error) {
+	doc, err := fetchDiscoveryDocument(ctx, options.Client, discoveryURL, options.AllowInsecureDiscovery)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithOptions(doc.ApplyTo(options)), nil
+}
+
+// NewWithDiscoveryDocument creates a Client configured from a discovery document supplied as bytes,
+// for example one bundled with the application or loaded from configuration.
+func NewWithDiscoveryDocument(document []byte, options ClientOptions) (*Client, error) {
+	doc, err := parseDiscoveryDocument(document, options.AllowInsecureDiscovery)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithOptions(doc.ApplyTo(options)), nil
+}
+
+// validateEndpointURL checks that raw is an absolute https URL, or http when allowInsecure is set.
+// The token and revoke endpoints receive the client secret, which must not travel in plaintext.
+func validateEndpointURL(raw string, allowInsecure bool) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if allowInsecure {
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%q is not an absolute http(s) URL", raw)
+		}
+		return nil
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute https URL", raw)
+	}
+	return nil
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
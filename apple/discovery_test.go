@@ -0,0 +1,192 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appleDiscoveryDocument mirrors https://appleid.apple.com/.well-known/openid-configuration
+const appleDiscoveryDocument = `{
+	"issuer": "https://appleid.apple.com",
+	"authorization_endpoint": "https://appleid.apple.com/auth/authorize",
+	"token_endpoint": "https://appleid.apple.com/auth/token",
+	"revocation_endpoint": "https://appleid.apple.com/auth/revoke",
+	"jwks_uri": "https://appleid.apple.com/auth/keys",
+	"response_types_supported": ["code"],
+	"response_modes_supported": ["query", "fragment", "form_post"],
+	"subject_types_supported": ["pairwise"],
+	"id_token_signing_alg_values_supported": ["RS256"],
+	"scopes_supported": ["openid", "email", "name"],
+	"token_endpoint_auth_methods_supported": ["client_secret_post"],
+	"claims_supported": ["aud", "email", "email_verified", "exp", "iat", "is_private_email", "iss", "nonce", "nonce_supported", "real_user_status", "sub", "transfer_sub"]
+}`
+
+func TestParseDiscoveryDocument(t *testing.T) {
+	doc, err := ParseDiscoveryDocument([]byte(appleDiscoveryDocument))
+	require.NoError(t, err)
+	assert.Equal(t, AppleIssuer, doc.Issuer)
+	assert.Equal(t, ValidationURL, doc.TokenEndpoint)
+	assert.Equal(t, RevokeURL, doc.RevocationEndpoint)
+	assert.Equal(t, AppleKeysURL, doc.JWKSURI)
+
+	tests := []struct {
+		name    string
+		replace [2]string
+		wantErr string
+	}{
+		{
+			name:    "unsupported signing algorithm",
+			replace: [2]string{`["RS256"]`, `["ES512"]`},
+			wantErr: "signing algorithms",
+		},
+		{
+			name:    "unsupported response modes",
+			replace: [2]string{`["query", "fragment", "form_post"]`, `["web_message"]`},
+			wantErr: "response modes",
+		},
+		{
+			name:    "missing token endpoint",
+			replace: [2]string{`"token_endpoint": "https://appleid.apple.com/auth/token",`, ``},
+			wantErr: "missing token_endpoint",
+		},
+		{
+			name:    "relative jwks uri",
+			replace: [2]string{`"https://appleid.apple.com/auth/keys"`, `"/auth/keys"`},
+			wantErr: "jwks_uri",
+		},
+		{
+			name:    "plaintext token endpoint",
+			replace: [2]string{`"https://appleid.apple.com/auth/token"`, `"http://appleid.apple.com/auth/token"`},
+			wantErr: "token_endpoint",
+		},
+		{
+			name:    "no client_secret_post",
+			replace: [2]string{`["client_secret_post"]`, `["private_key_jwt"]`},
+			wantErr: "client_secret_post",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDiscoveryDocument([]byte(strings.Replace(appleDiscoveryDocument, tt.replace[0], tt.replace[1], 1)))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+
+	_, err = ParseDiscoveryDocument([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestDiscoveryDocumentApplyTo(t *testing.T) {
+	doc := &DiscoveryDocument{
+		Issuer:             "https://issuer.test",
+		TokenEndpoint:      "https://issuer.test/token",
+		RevocationEndpoint: "https://issuer.test/revoke",
+		JWKSURI:            "https://issuer.test/keys",
+	}
+
+	opts := doc.ApplyTo(ClientOptions{RevokeURL: "https://override.test/revoke"})
+	assert.Equal(t, "https://issuer.test/token", opts.ValidationURL)
+	assert.Equal(t, "https://override.test/revoke", opts.RevokeURL, "explicit options take precedence")
+	assert.Equal(t, "https://issuer.test/keys", opts.AppleKeysURL)
+	assert.Equal(t, "https://issuer.test", opts.Issuer)
+}
+
+func TestNewWithDiscovery(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+
+	mux := http.NewServeMux()
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, UserAgent, r.Header.Get("user-agent"))
+		w.Write([]byte(`{
+			"issuer": "` + srv.URL + `",
+			"token_endpoint": "` + srv.URL + `/auth/token",
+			"revocation_endpoint": "` + srv.URL + `/auth/revoke",
+			"jwks_uri": "` + srv.URL + `/auth/keys",
+			"id_token_signing_alg_values_supported": ["RS256"]
+		}`))
+	})
+	mux.HandleFunc("/auth/keys", jwksHandler)
+
+	c, err := NewWithDiscovery(context.Background(), srv.URL+"/.well-known/openid-configuration", ClientOptions{Client: srv.Client()})
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/auth/token", c.config.Load().validationURL)
+	assert.Equal(t, srv.URL+"/auth/revoke", c.config.Load().revokeURL)
+	assert.Equal(t, MigrationURL, c.config.Load().migrationURL, "endpoints absent from the document keep their defaults")
+
+	// Tokens are verified against the discovered issuer and key set
+	token := makeIDToken(t, privKey, jwt.MapClaims{
+		"iss": srv.URL,
+		"aud": "com.example.app",
+		"sub": "user123",
+		"iat": float64(time.Now().Unix()),
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+	claims, err := c.VerifyIDToken(context.Background(), token, "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, "user123", claims.Subject)
+
+	appleToken := makeIDToken(t, privKey, jwt.MapClaims{
+		"iss": AppleIssuer,
+		"aud": "com.example.app",
+		"sub": "user123",
+		"iat": float64(time.Now().Unix()),
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+	_, err = c.VerifyIDToken(context.Background(), appleToken, "com.example.app")
+	assert.Error(t, err, "tokens from a different issuer must be rejected")
+
+	_, err = NewWithDiscovery(context.Background(), srv.URL+"/missing/.well-known/openid-configuration", ClientOptions{Client: srv.Client()})
+	assert.ErrorContains(t, err, "HTTP 404")
+	_, err = NewWithDiscovery(context.Background(), srv.URL+"/missing", ClientOptions{Client: srv.Client()})
+	assert.ErrorContains(t, err, "does not end with")
+}
+
+func TestFetchDiscoveryDocumentChecksIssuerAndScheme(t *testing.T) {
+	var issuer string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"issuer": "` + issuer + `",
+			"token_endpoint": "http://` + r.Host + `/auth/token",
+			"jwks_uri": "http://` + r.Host + `/auth/keys",
+			"id_token_signing_alg_values_supported": ["RS256"]
+		}`))
+	}))
+	defer srv.Close()
+	discoveryURL := srv.URL + "/.well-known/openid-configuration"
+
+	issuer = srv.URL
+	_, err := FetchDiscoveryDocument(context.Background(), nil, discoveryURL)
+	assert.ErrorContains(t, err, "not an absolute https URL", "plaintext endpoints would expose the client secret")
+
+	c, err := NewWithDiscovery(context.Background(), discoveryURL, ClientOptions{AllowInsecureDiscovery: true})
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/auth/token", c.config.Load().validationURL)
+
+	issuer = AppleIssuer
+	_, err = NewWithDiscovery(context.Background(), discoveryURL, ClientOptions{AllowInsecureDiscovery: true})
+	assert.ErrorContains(t, err, "does not match", "a document may not claim another issuer")
+}
+
+func TestNewWithDiscoveryDocument(t *testing.T) {
+	c, err := NewWithDiscoveryDocument([]byte(appleDiscoveryDocument), ClientOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ValidationURL, c.config.Load().validationURL)
+	assert.Equal(t, AppleKeysURL, c.config.Load().keysURL)
+	assert.Equal(t, AppleIssuer, c.config.Load().issuer)
+
+	_, err = NewWithDiscoveryDocument([]byte(`{}`), ClientOptions{})
+	assert.Error(t, err)
+}
@@ -58,10 +58,14 @@
 // Apple sends a signed JWT to a registered webhook URL when a user revokes access
 // or deletes their Apple ID. Use [Client.ParseServerNotification] to verify the
 // RS256 signature and parse the event. You must delete all user data within 30 days
-// of an account-delete event. See Apple's TN3194 for details.
+// of an account-delete event. See Apple's TN3194 for details. [DeletionOrchestrator]
+// carries out that deletion: it revokes the user's tokens kept in a [TokenStore], runs your
+// data-deletion hooks with retries and reports deletions nearing the deadline.
 //
 // # Customisation
 //
 // Use [NewWithOptions] to supply a custom HTTP client, timeout, JWKS cache TTL,
 // or override individual endpoint URLs (useful for testing against a mock server).
+// [NewWithDiscovery] configures the endpoints and issuer from an OpenID Connect
+// discovery document instead.
 package apple
@@ -0,0 +1,241 @@
+package apple
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Severity grades a Finding
+type Severity int
+
+const (
+	// SeverityError findings will make Apple reject requests
+	SeverityError Severity = iota
+	// SeverityWarning findings are likely mistakes that Apple may still accept
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Finding is a single problem found by ValidateConfig
+type Finding struct {
+	// Check names the area of configuration, e.g. "team_id" or "redirect_uris"
+	Check    string
+	Severity Severity
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Check, f.Message)
+}
+
+// ConfigReport lists the findings of ValidateConfig
+type ConfigReport struct {
+	Findings []Finding
+}
+
+// OK reports whether no errors were found. Warnings do not count.
+func (r *ConfigReport) OK() bool {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the report one finding per line
+func (r *ConfigReport) String() string {
+	if len(r.Findings) == 0 {
+		return "no problems found"
+	}
+	lines := make([]string, 0, len(r.Findings))
+	for _, f := range r.Findings {
+		lines = append(lines, f.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *ConfigReport) errorf(check, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Check: check, Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *ConfigReport) warnf(check, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Check: check, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// appleIDPattern matches Team IDs and Key IDs: 10 upper-case letters or digits
+var appleIDPattern = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+
+// keyFilePattern matches the file name Apple gives downloaded keys
+var keyFilePattern = regexp.MustCompile(`^AuthKey_([A-Z0-9]{10})\.p8$`)
+
+// ValidateConfig diagnoses the common causes of invalid_client without contacting Apple. Unlike
+// Config.Validate, which only checks the configuration is complete, it checks Team ID and Key ID
+// formats, spots IDs that were swapped or prefixed, confirms the signing key is a P-256 key, signs a
+// client secret and verifies it against the key's public half, and checks RedirectURIs against
+// Apple's rules. Every finding is returned in the report rather than stopping at the first.
+func ValidateConfig(cfg *Config) *ConfigReport {
+	r := &ConfigReport{}
+
+	checkAppleID(r, "team_id", "Team ID", cfg.TeamID)
+	checkAppleID(r, "key_id", "Key ID", cfg.KeyID)
+	if cfg.TeamID != "" && strings.EqualFold(cfg.TeamID, cfg.KeyID) {
+		r.errorf("key_id", "Key ID is the same as the Team ID; copy the Key ID from Certificates, Identifiers & Profiles > Keys")
+	}
+
+	if cfg.PrivateKeyPath != "" {
+		if m := keyFilePattern.FindStringSubmatch(filepath.Base(cfg.PrivateKeyPath)); m != nil && m[1] != cfg.KeyID {
+			if m[1] == cfg.TeamID {
+				r.errorf("key_id", "Team ID and Key ID appear to be swapped: the key file is named after %s, which is configured as the Team ID", m[1])
+			} else {
+				r.warnf("key_id", "key file %s is named after Key ID %s but the configured Key ID is %q", filepath.Base(cfg.PrivateKeyPath), m[1], cfg.KeyID)
+			}
+		}
+	}
+
+	checkClientIDs(r, cfg)
+
+	var key *ecdsa.PrivateKey
+	switch {
+	case cfg.PrivateKeyPath == "" && cfg.PrivateKey == "":
+		r.errorf("private_key", "no signing key configured; set private_key_path or private_key")
+	case cfg.PrivateKeyPath != "" && cfg.PrivateKey != "":
+		r.errorf("private_key", "both private_key_path and private_key are set; use one")
+	default:
+		var err error
+		key, err = cfg.SigningKey()
+		if err != nil {
+			r.errorf("private_key", "%v", err)
+		}
+	}
+
+	if key != nil && cfg.TeamID != "" && cfg.KeyID != "" && len(cfg.ClientIDs) > 0 {
+		checkClientSecretRoundTrip(r, cfg, key)
+	}
+
+	for _, uri := range cfg.RedirectURIs {
+		checkRedirectURI(r, uri)
+	}
+
+	return r
+}
+
+func checkAppleID(r *ConfigReport, check, name, value string) {
+	switch {
+	case value == "":
+		r.errorf(check, "%s is required", name)
+	case strings.TrimSpace(value) != value:
+		r.errorf(check, "%s %q has leading or trailing whitespace", name, value)
+	case appleIDPattern.MatchString(strings.ToUpper(value)) && !appleIDPattern.MatchString(value):
+		r.errorf(check, "%s %q must be upper case", name, value)
+	case !appleIDPattern.MatchString(value):
+		r.errorf(check, "%s %q must be exactly 10 upper-case letters or digits", name, value)
+	}
+}
+
+func checkClientIDs(r *ConfigReport, cfg *Config) {
+	if len(cfg.ClientIDs) == 0 {
+		r.errorf("client_ids", "at least one client ID is required")
+		return
+	}
+	seen := make(map[string]bool, len(cfg.ClientIDs))
+	for _, id := range cfg.ClientIDs {
+		switch {
+		case strings.TrimSpace(id) == "":
+			r.errorf("client_ids", "client ID is empty")
+			continue
+		case strings.TrimSpace(id) != id:
+			r.errorf("client_ids", "client ID %q has leading or trailing whitespace", id)
+		case id == cfg.TeamID || id == cfg.KeyID:
+			r.errorf("client_ids", "client ID %q is a Team ID or Key ID; use the Services ID or bundle ID", id)
+		}
+
+		if prefix, rest, ok := strings.Cut(id, "."); ok && appleIDPattern.MatchString(prefix) && strings.Contains(rest, ".") {
+			// e.g. ABCDE12345.com.example.app copied from the App ID page
+			r.errorf("client_ids", "client ID %q includes the App ID prefix %s; use %q", id, prefix, rest)
+		} else if !strings.Contains(id, ".") && id != cfg.TeamID && id != cfg.KeyID {
+			r.warnf("client_ids", "client ID %q is not in reverse-domain form; Services IDs and bundle IDs usually are", id)
+		}
+
+		if seen[id] {
+			r.warnf("client_ids", "client ID %q is listed more than once", id)
+		}
+		seen[id] = true
+	}
+}
+
+// checkClientSecretRoundTrip signs a secret and verifies it with the public key, the same check Apple performs
+func checkClientSecretRoundTrip(r *ConfigReport, cfg *Config, key *ecdsa.PrivateKey) {
+	signer, err := NewSoftwareSignerFromKey(key)
+	if err != nil {
+		r.errorf("client_secret", "%v", err)
+		return
+	}
+	clientID := cfg.ClientIDs[0]
+	secret, err := SignClientSecret(signer, ClientSecretOptions{
+		TeamID:   cfg.TeamID,
+		ClientID: clientID,
+		KeyID:    cfg.KeyID,
+		Lifetime: time.Duration(cfg.ClientSecretLifetime),
+	})
+	if err != nil {
+		r.errorf("client_secret", "signing a client secret failed: %v", err)
+		return
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(secret.Token, claims, func(token *jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}),
+		jwt.WithIssuer(cfg.TeamID),
+		jwt.WithSubject(clientID),
+		jwt.WithAudience(AppleIssuer),
+	)
+	if err != nil {
+		r.errorf("client_secret", "generated client secret does not verify against the key's public half: %v", err)
+		return
+	}
+	if kid, _ := token.Header["kid"].(string); kid != cfg.KeyID {
+		r.errorf("client_secret", "generated client secret has kid %q, want %q", kid, cfg.KeyID)
+	}
+}
+
+// checkRedirectURI applies Apple's rules for Return URLs registered on a Services ID
+func checkRedirectURI(r *ConfigReport, raw string) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		r.errorf("redirect_uris", "%q is not an absolute URL", raw)
+		return
+	}
+	if u.Scheme != "https" {
+		r.errorf("redirect_uris", "%q must use https", raw)
+	}
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) != nil {
+		r.errorf("redirect_uris", "%q uses an IP address; Apple requires a domain name", raw)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		r.errorf("redirect_uris", "%q points at localhost, which Apple does not accept; use a tunnel with a public domain for local testing", raw)
+	}
+	if u.Fragment != "" || strings.HasSuffix(raw, "#") {
+		r.errorf("redirect_uris", "%q must not contain a fragment", raw)
+	}
+	if u.User != nil {
+		r.errorf("redirect_uris", "%q must not contain user information", raw)
+	}
+}
@@ -0,0 +1,113 @@
+package apple
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func findingsFor(report *ConfigReport, check string) []Finding {
+	var found []Finding
+	for _, f := range report.Findings {
+		if f.Check == check {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+func TestValidateConfigOK(t *testing.T) {
+	report := ValidateConfig(&Config{
+		TeamID:       "TEAM000001",
+		KeyID:        "KEY0000001",
+		ClientIDs:    []string{"com.example.web", "com.example.app"},
+		PrivateKey:   testSigningKey,
+		RedirectURIs: []string{"https://example.com/auth/apple/callback"},
+	})
+	assert.True(t, report.OK(), report.String())
+	assert.Empty(t, report.Findings)
+	assert.Equal(t, "no problems found", report.String())
+}
+
+func TestValidateConfigIDs(t *testing.T) {
+	report := ValidateConfig(&Config{
+		TeamID:         "KEY0000001",
+		KeyID:          "team00001",
+		ClientIDs:      []string{"TEAM000001.com.example.app", "webapp", "webapp"},
+		PrivateKeyPath: "/secrets/AuthKey_KEY0000001.p8",
+	})
+	assert.False(t, report.OK())
+
+	keyFindings := findingsFor(report, "key_id")
+	require.Len(t, keyFindings, 2)
+	assert.Contains(t, keyFindings[0].Message, "exactly 10")
+	assert.Contains(t, keyFindings[1].Message, "swapped")
+
+	clientFindings := findingsFor(report, "client_ids")
+	require.Len(t, clientFindings, 4)
+	assert.Contains(t, clientFindings[0].Message, `use "com.example.app"`)
+	assert.Equal(t, SeverityWarning, clientFindings[1].Severity)
+	assert.Contains(t, clientFindings[3].Message, "more than once")
+
+	assert.Len(t, findingsFor(report, "private_key"), 1, "the missing key file is reported")
+
+	report = ValidateConfig(&Config{TeamID: "team000001", KeyID: "TEAM000001"})
+	assert.Contains(t, findingsFor(report, "team_id")[0].Message, "upper case")
+	assert.Contains(t, findingsFor(report, "key_id")[0].Message, "same as the Team ID")
+}
+
+func TestValidateConfigKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	report := ValidateConfig(&Config{
+		TeamID:     "TEAM000001",
+		KeyID:      "KEY0000001",
+		ClientIDs:  []string{"com.example.web"},
+		PrivateKey: encodePEM(t, "PRIVATE KEY", der),
+	})
+	found := findingsFor(report, "private_key")
+	require.Len(t, found, 1)
+	assert.Contains(t, found[0].Message, "RSA key")
+	assert.Empty(t, findingsFor(report, "client_secret"), "no secret is signed without a usable key")
+}
+
+func TestValidateConfigRedirectURIs(t *testing.T) {
+	report := ValidateConfig(&Config{
+		TeamID:     "TEAM000001",
+		KeyID:      "KEY0000001",
+		ClientIDs:  []string{"com.example.web"},
+		PrivateKey: testSigningKey,
+		RedirectURIs: []string{
+			"https://example.com/callback",
+			"http://example.com/callback",
+			"https://192.168.1.10/callback",
+			"https://[::1]/callback",
+			"https://localhost:8443/callback",
+			"https://app.localhost/callback",
+			"https://example.com/callback#done",
+			"/callback",
+		},
+	})
+
+	found := findingsFor(report, "redirect_uris")
+	messages := make([]string, 0, len(found))
+	for _, f := range found {
+		messages = append(messages, f.Message)
+	}
+	joined := strings.Join(messages, "\n")
+	assert.Len(t, found, 7, joined)
+	assert.Contains(t, joined, "must use https")
+	assert.Contains(t, joined, "IP address")
+	assert.Contains(t, joined, "localhost")
+	assert.Contains(t, joined, "fragment")
+	assert.Contains(t, joined, "not an absolute URL")
+	assert.NotContains(t, joined, `"https://example.com/callback"`)
+}
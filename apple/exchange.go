@@ -0,0 +1,120 @@
+package apple
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrMissingIDToken is returned by Exchange and Refresh when Apple's response carries no id_token
+	ErrMissingIDToken = errors.New("apple response has no id_token")
+
+	// ErrInvalidIDToken wraps the verification error of an id_token returned by Apple
+	ErrInvalidIDToken = errors.New("invalid id_token")
+
+	// ErrNonceMismatch is returned by Exchange when the id_token's nonce differs from the expected one
+	ErrNonceMismatch = errors.New("id_token nonce does not match")
+)
+
+// ExchangeRequest describes an authorization code to exchange with Exchange
+type ExchangeRequest struct {
+	// ClientID is the Services ID for web sign-in or the bundle ID for app sign-in. The id_token's
+	// audience must match it.
+	ClientID string
+
+	// ClientSecret is the client secret. It may be left empty when ClientOptions.Secrets is set.
+	ClientSecret string
+
+	// Code is the authorization code. It is single use and valid for five minutes.
+	Code string
+
+	// RedirectURI is the redirect URI the code was sent to. Set it for web sign-in; leave it empty
+	// for codes received by an app.
+	RedirectURI string
+
+	// Nonce, when set, must equal the nonce claim of the id_token. Pass the value sent in the
+	// authorization request, hashed the same way if the app hashed it.
+	Nonce string
+}
+
+// Session is the result of a successful Exchange
+type Session struct {
+	// AccessToken is reserved by Apple for future use
+	AccessToken string
+
+	// TokenType is always "bearer"
+	TokenType string
+
+	// RefreshToken can be stored to validate the user later and to revoke their tokens
+	RefreshToken string
+
+	// ExpiresAt is when the access token expires, computed from expires_in
+	ExpiresAt time.Time
+
+	// IDToken is the raw id_token
+	IDToken string
+
+	// Claims are the verified claims of the id_token
+	Claims *IDTokenClaims
+}
+
+// Exchange redeems an authorization code from web or app sign-in and returns the verified session.
+//
+// It calls Apple's token endpoint, verifies the returned id_token with VerifyIDToken (signature,
+// issuer, expiry and audience against ClientID), checks the nonce when one is given and turns expires_in
+// into an absolute time. Apple errors are returned as *APIError; id_token problems wrap ErrMissingIDToken,
+// ErrInvalidIDToken or ErrNonceMismatch.
+func (c *Client) Exchange(ctx context.Context, req ExchangeRequest) (*Session, error) {
+	if req.ClientID == "" || req.Code == "" {
+		return nil, errors.New("client ID and authorization code are required")
+	}
+
+	requestedAt := c.config.Load().clock.Now()
+	var resp *ValidationResponse
+	var err error
+	if req.RedirectURI != "" {
+		resp, err = Send[ValidationResponse](ctx, c, WebValidationTokenRequest{
+			ClientID:     req.ClientID,
+			ClientSecret: req.ClientSecret,
+			Code:         req.Code,
+			RedirectURI:  req.RedirectURI,
+		})
+	} else {
+		resp, err = Send[ValidationResponse](ctx, c, AppValidationTokenRequest{
+			ClientID:     req.ClientID,
+			ClientSecret: req.ClientSecret,
+			Code:         req.Code,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.newSession(ctx, req, resp, requestedAt)
+}
+
+// newSession verifies the id_token of a successful token response and builds the Session
+func (c *Client) newSession(ctx context.Context, req ExchangeRequest, resp *ValidationResponse, requestedAt time.Time) (*Session, error) {
+	if resp.IDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+	claims, err := c.VerifyIDToken(ctx, resp.IDToken, req.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+	if req.Nonce != "" && subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(req.Nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	return &Session{
+		AccessToken:  resp.AccessToken,
+		TokenType:    resp.TokenType,
+		RefreshToken: resp.RefreshToken,
+		ExpiresAt:    requestedAt.Add(time.Duration(resp.ExpiresIn) * time.Second),
+		IDToken:      resp.IDToken,
+		Claims:       claims,
+	}, nil
+}
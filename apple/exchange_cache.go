@@ -0,0 +1,197 @@
+package apple
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuthorizationCodeLifetime is how long Apple accepts an authorization code, and so how long a
+// successful exchange is kept in an ExchangeCache
+const AuthorizationCodeLifetime = 5 * time.Minute
+
+// ExchangeCache stores the token responses of successful authorization code exchanges, so a client
+// retrying an exchange Apple already accepted gets the original response instead of invalid_grant.
+// Values are opaque to the cache: each holds Apple's response and the time it was requested, so the
+// expires_in of a replay can be counted from when Apple issued the tokens.
+//
+// Keys are SHA-256 hashes of the client ID, redirect URI and code; codes themselves are never stored.
+// Values hold refresh and id tokens, so a shared implementation (e.g. Redis) must keep them private.
+type ExchangeCache interface {
+	// Get returns the response stored under key, if it has not expired
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+type exchangeCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// MemoryExchangeCache is an in-process ExchangeCache. It suits a single server; deployments behind a
+// load balancer need a shared cache so a retry reaching another instance is served too.
+type MemoryExchangeCache struct {
+	clock Clock
+
+	mu      sync.Mutex
+	entries map[string]exchangeCacheEntry
+}
+
+// NewMemoryExchangeCache creates an empty MemoryExchangeCache. A nil clock uses SystemClock.
+func NewMemoryExchangeCache(clock Clock) *MemoryExchangeCache {
+	return &MemoryExchangeCache{
+		clock:   clockOrDefault(clock),
+		entries: make(map[string]exchangeCacheEntry),
+	}
+}
+
+// Get returns the unexpired value stored under key
+func (m *MemoryExchangeCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !m.clock.Now().Before(entry.expires) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+// Set stores value under key for ttl and drops expired entries
+func (m *MemoryExchangeCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	for k, entry := range m.entries {
+		if !now.Before(entry.expires) {
+			delete(m.entries, k)
+		}
+	}
+	m.entries[key] = exchangeCacheEntry{value: append([]byte(nil), value...), expires: now.Add(ttl)}
+	return nil
+}
+
+// cachedExchange is the ExchangeCache value of a successful exchange
+type cachedExchange struct {
+	RequestedAt time.Time       `json:"requested_at"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// replay returns the cached response with expires_in reduced by the time elapsed since it was requested,
+// so the access token's expiry is not pushed back by the replay
+func (e cachedExchange) replay(now time.Time) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(e.Body, &fields); err != nil {
+		return nil, err
+	}
+	raw, ok := fields["expires_in"]
+	if !ok {
+		return e.Body, nil
+	}
+	var expiresIn int64
+	if err := json.Unmarshal(raw, &expiresIn); err != nil {
+		return nil, err
+	}
+	expiresIn -= int64(now.Sub(e.RequestedAt) / time.Second)
+	if expiresIn < 0 {
+		expiresIn = 0
+	}
+	fields["expires_in"], _ = json.Marshal(expiresIn)
+	return json.Marshal(fields)
+}
+
+// exchangeCall is an authorization code exchange shared by every caller presenting the same code
+type exchangeCall struct {
+	done   chan struct{}
+	status int
+	body   []byte
+	err    error
+}
+
+// exchangeKey returns the ExchangeCache key of req, and false when req is not an authorization code exchange
+func exchangeKey(req Request) (string, bool) {
+	var clientID, redirectURI, code string
+	switch r := req.(type) {
+	case WebValidationTokenRequest:
+		clientID, redirectURI, code = r.ClientID, r.RedirectURI, r.Code
+	case AppValidationTokenRequest:
+		clientID, code = r.ClientID, r.Code
+	default:
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(clientID + "\x00" + redirectURI + "\x00" + code))
+	return hex.EncodeToString(sum[:]), true
+}
+
+// exchangeOnce serves a code exchange from the cache, or joins the exchange in flight for the same code,
+// or starts one. The shared exchange runs detached from ctx so a caller giving up does not lose a
+// response Apple has already produced; it is bounded by the HTTP client's timeout instead. Cache
+// errors and unreadable values are treated as a miss, so an unavailable cache never fails a sign-in.
+func (c *Client) exchangeOnce(ctx context.Context, cfg *clientConfig, req Request, key string) (int, []byte, error) {
+	if value, found, err := cfg.exchangeCache.Get(ctx, key); err == nil && found {
+		var cached cachedExchange
+		if json.Unmarshal(value, &cached) == nil {
+			if body, err := cached.replay(cfg.clock.Now()); err == nil {
+				return http.StatusOK, body, nil
+			}
+		}
+	}
+
+	c.exchangesMu.Lock()
+	call, running := c.exchanges[key]
+	if !running {
+		if c.exchanges == nil {
+			c.exchanges = make(map[string]*exchangeCall)
+		}
+		call = &exchangeCall{done: make(chan struct{})}
+		c.exchanges[key] = call
+		go c.runExchange(context.WithoutCancel(ctx), cfg, req, key, call)
+	}
+	c.exchangesMu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.status, call.body, call.err
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+}
+
+// runExchange performs call and caches its response if Apple accepted the code
+func (c *Client) runExchange(ctx context.Context, cfg *clientConfig, req Request, key string, call *exchangeCall) {
+	requestedAt := cfg.clock.Now()
+	call.status, call.body, call.err = cfg.send(ctx, req)
+	if call.err == nil && exchangeSucceeded(call.status, call.body) {
+		// A failed write only loses the protection against retries; the exchange itself succeeded
+		if value, err := json.Marshal(cachedExchange{RequestedAt: requestedAt, Body: call.body}); err == nil {
+			_ = cfg.exchangeCache.Set(ctx, key, value, AuthorizationCodeLifetime)
+		}
+	}
+
+	c.exchangesMu.Lock()
+	delete(c.exchanges, key)
+	c.exchangesMu.Unlock()
+	close(call.done)
+}
+
+// exchangeSucceeded reports whether Apple accepted the code: a 2xx status without an error field
+func exchangeSucceeded(status int, body []byte) bool {
+	if status < 200 || status >= 300 {
+		return false
+	}
+	var resp struct {
+		Error string `json:"error"`
+	}
+	return json.Unmarshal(body, &resp) == nil && resp.Error == ""
+}
@@ -0,0 +1,142 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCountingTokenServer answers every request with status and body after release is closed
+func newCountingTokenServer(t *testing.T, status int, body string, release <-chan struct{}) (string, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if release != nil {
+			<-release
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &calls
+}
+
+func TestExchangeCacheCoalescesAndReplays(t *testing.T) {
+	release := make(chan struct{})
+	url, calls := newCountingTokenServer(t, http.StatusOK, `{"access_token":"a","refresh_token":"r","id_token":"i"}`, release)
+	c := NewWithOptions(ClientOptions{ValidationURL: url, ExchangeCache: NewMemoryExchangeCache(nil)})
+	req := AppValidationTokenRequest{ClientID: "cid", ClientSecret: "s", Code: "code"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Typed().VerifyAppToken(context.Background(), req)
+			assert.NoError(t, err)
+			assert.Equal(t, "r", resp.RefreshToken)
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(calls) == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls), "concurrent exchanges share one Apple call")
+
+	var resp ValidationResponse
+	require.NoError(t, c.VerifyAppToken(context.Background(), req, &resp))
+	assert.Equal(t, "i", resp.IDToken)
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls), "a replay is served from the cache")
+
+	req.Code = "other"
+	require.NoError(t, c.VerifyAppToken(context.Background(), req, &resp))
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+}
+
+func TestExchangeCacheSkipsFailures(t *testing.T) {
+	url, calls := newCountingTokenServer(t, http.StatusBadRequest, `{"error":"invalid_grant"}`, nil)
+	c := NewWithOptions(ClientOptions{ValidationURL: url, ExchangeCache: NewMemoryExchangeCache(nil)})
+	req := WebValidationTokenRequest{ClientID: "cid", ClientSecret: "s", Code: "code", RedirectURI: "https://example.com/cb"}
+
+	for i := 0; i < 2; i++ {
+		_, err := c.Typed().VerifyWebToken(context.Background(), req)
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, "invalid_grant", apiErr.Code)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls), "errors are not cached")
+}
+
+func TestExchangeCacheIgnoresOtherRequests(t *testing.T) {
+	url, calls := newCountingTokenServer(t, http.StatusOK, `{"access_token":"a"}`, nil)
+	c := NewWithOptions(ClientOptions{ValidationURL: url, ExchangeCache: NewMemoryExchangeCache(nil)})
+
+	for i := 0; i < 2; i++ {
+		_, err := c.Typed().VerifyRefreshToken(context.Background(), ValidationRefreshRequest{ClientID: "cid", ClientSecret: "s", RefreshToken: "r"})
+		require.NoError(t, err)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(calls))
+}
+
+func TestExchangeCacheCallerGivesUp(t *testing.T) {
+	release := make(chan struct{})
+	url, calls := newCountingTokenServer(t, http.StatusOK, `{"refresh_token":"r"}`, release)
+	cache := NewMemoryExchangeCache(nil)
+	c := NewWithOptions(ClientOptions{ValidationURL: url, ExchangeCache: cache})
+	req := AppValidationTokenRequest{ClientID: "cid", ClientSecret: "s", Code: "code"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.Typed().VerifyAppToken(ctx, req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	key, _ := exchangeKey(req)
+	require.Eventually(t, func() bool {
+		_, found, _ := cache.Get(context.Background(), key)
+		return found
+	}, time.Second, time.Millisecond, "the exchange completes and is cached after the caller gave up")
+
+	resp, err := c.Typed().VerifyAppToken(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "r", resp.RefreshToken)
+	assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+}
+
+func TestMemoryExchangeCacheExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	cache := NewMemoryExchangeCache(clock)
+	ctx := context.Background()
+
+	require.NoError(t, cache.Set(ctx, "k", []byte("v"), AuthorizationCodeLifetime))
+	value, found, err := cache.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("v"), value)
+
+	clock.Advance(AuthorizationCodeLifetime)
+	_, found, _ = cache.Get(ctx, "k")
+	assert.False(t, found)
+}
+
+func TestExchangeKey(t *testing.T) {
+	app, ok := exchangeKey(AppValidationTokenRequest{ClientID: "cid", Code: "code"})
+	require.True(t, ok)
+	assert.NotContains(t, app, "code")
+
+	web, _ := exchangeKey(WebValidationTokenRequest{ClientID: "cid", Code: "code", RedirectURI: "https://example.com/cb"})
+	other, _ := exchangeKey(AppValidationTokenRequest{ClientID: "other", Code: "code"})
+	assert.NotEqual(t, app, web)
+	assert.NotEqual(t, app, other)
+
+	_, ok = exchangeKey(ValidationRefreshRequest{})
+	assert.False(t, ok)
+}
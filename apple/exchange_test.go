@@ -0,0 +1,158 @@
+package apple
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newExchangeTestClient serves JWKS and a token endpoint that answers with the body built by respond
+func newExchangeTestClient(t *testing.T, clock Clock, respond func(r *http.Request) (int, interface{})) *Client {
+	t.Helper()
+	_, jwksHandler := generateTestKey(t)
+	return newExchangeTestClientWithJWKS(t, clock, jwksHandler, respond)
+}
+
+func newExchangeTestClientWithJWKS(t *testing.T, clock Clock, jwksHandler http.HandlerFunc, respond func(r *http.Request) (int, interface{})) *Client {
+	t.Helper()
+	jwksSrv := httptest.NewServer(jwksHandler)
+	t.Cleanup(jwksSrv.Close)
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		status, body := respond(r)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(tokenSrv.Close)
+	return NewWithOptions(ClientOptions{ValidationURL: tokenSrv.URL, AppleKeysURL: jwksSrv.URL, Clock: clock})
+}
+
+func exchangeTestClaims(now time.Time, aud, nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   AppleIssuer,
+		"aud":   aud,
+		"sub":   "user123",
+		"nonce": nonce,
+		"iat":   float64(now.Unix()),
+		"exp":   float64(now.Add(10 * time.Minute).Unix()),
+	}
+}
+
+func TestExchange(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+	now := time.Now().Truncate(time.Second)
+	clock := NewFakeClock(now)
+
+	var form map[string][]string
+	c := newExchangeTestClientWithJWKS(t, clock, jwksHandler, func(r *http.Request) (int, interface{}) {
+		form = r.PostForm
+		return http.StatusOK, ValidationResponse{
+			AccessToken:  "access",
+			TokenType:    "bearer",
+			ExpiresIn:    3600,
+			RefreshToken: "refresh",
+			IDToken:      makeIDToken(t, privKey, exchangeTestClaims(now, r.PostForm.Get("client_id"), "n-0S6")),
+		}
+	})
+
+	t.Run("web", func(t *testing.T) {
+		session, err := c.Exchange(context.Background(), ExchangeRequest{
+			ClientID:     "com.example.web",
+			ClientSecret: "secret",
+			Code:         "code",
+			RedirectURI:  "https://example.com/callback",
+			Nonce:        "n-0S6",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/callback", form["redirect_uri"][0])
+		assert.Equal(t, "access", session.AccessToken)
+		assert.Equal(t, "refresh", session.RefreshToken)
+		assert.Equal(t, now.Add(time.Hour), session.ExpiresAt)
+		assert.Equal(t, "user123", session.Claims.Subject)
+		assert.Equal(t, "com.example.web", session.Claims.Audience)
+		assert.NotEmpty(t, session.IDToken)
+	})
+
+	t.Run("app", func(t *testing.T) {
+		session, err := c.Exchange(context.Background(), ExchangeRequest{ClientID: "com.example.app", ClientSecret: "secret", Code: "code"})
+		require.NoError(t, err)
+		assert.NotContains(t, form, "redirect_uri")
+		assert.Equal(t, "com.example.app", session.Claims.Audience)
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		_, err := c.Exchange(context.Background(), ExchangeRequest{ClientID: "com.example.app", ClientSecret: "secret", Code: "code", Nonce: "other"})
+		assert.ErrorIs(t, err, ErrNonceMismatch)
+	})
+}
+
+func TestExchangeReplayKeepsExpiry(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+	now := time.Now().Truncate(time.Second)
+	clock := NewFakeClock(now)
+	calls := 0
+	c := newExchangeTestClientWithJWKS(t, clock, jwksHandler, func(r *http.Request) (int, interface{}) {
+		calls++
+		return http.StatusOK, ValidationResponse{
+			AccessToken: "access",
+			ExpiresIn:   3600,
+			IDToken:     makeIDToken(t, privKey, exchangeTestClaims(now, "com.example.app", "")),
+		}
+	})
+	options := c.Options()
+	options.ExchangeCache = NewMemoryExchangeCache(clock)
+	c.Reload(options)
+
+	req := ExchangeRequest{ClientID: "com.example.app", ClientSecret: "secret", Code: "code"}
+	first, err := c.Exchange(context.Background(), req)
+	require.NoError(t, err)
+
+	clock.Advance(2 * time.Minute)
+	replayed, err := c.Exchange(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls, "the retry is served from the cache")
+	assert.Equal(t, first.ExpiresAt, replayed.ExpiresAt, "a replay does not extend the access token's lifetime")
+}
+
+func TestExchangeInvalidIDToken(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+	now := time.Now()
+	c := newExchangeTestClientWithJWKS(t, nil, jwksHandler, func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, ValidationResponse{IDToken: makeIDToken(t, privKey, exchangeTestClaims(now, "com.example.other", ""))}
+	})
+
+	_, err := c.Exchange(context.Background(), ExchangeRequest{ClientID: "com.example.app", ClientSecret: "secret", Code: "code"})
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+}
+
+func TestExchangeMissingIDToken(t *testing.T) {
+	c := newExchangeTestClient(t, nil, func(r *http.Request) (int, interface{}) {
+		return http.StatusOK, ValidationResponse{AccessToken: "access"}
+	})
+
+	_, err := c.Exchange(context.Background(), ExchangeRequest{ClientID: "com.example.app", ClientSecret: "secret", Code: "code"})
+	assert.ErrorIs(t, err, ErrMissingIDToken)
+}
+
+func TestExchangeAPIError(t *testing.T) {
+	c := newExchangeTestClient(t, nil, func(r *http.Request) (int, interface{}) {
+		return http.StatusBadRequest, map[string]string{"error": "invalid_grant"}
+	})
+
+	session, err := c.Exchange(context.Background(), ExchangeRequest{ClientID: "com.example.app", ClientSecret: "secret", Code: "code"})
+	assert.Nil(t, session)
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_grant", apiErr.Code)
+
+	_, err = c.Exchange(context.Background(), ExchangeRequest{ClientID: "com.example.app"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,221 @@
+package apple
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnscriptedCall is returned by FakeClient for calls to methods that have no scripted response
+var ErrUnscriptedCall = errors.New("no response scripted for this FakeClient method")
+
+// fakeMethods are the method names accepted by FakeClient.Respond
+var fakeMethods = map[string]bool{
+	"VerifyWebToken":          true,
+	"VerifyAppToken":          true,
+	"VerifyRefreshToken":      true,
+	"RevokeAccessToken":       true,
+	"RevokeRefreshToken":      true,
+	"GetUserMigrationInfo":    true,
+	"VerifyIDToken":           true,
+	"ParseServerNotification": true,
+	"Exchange":                true,
+	"Refresh":                 true,
+}
+
+// FakeCall records one call made to a FakeClient
+type FakeCall struct {
+	// Method is the name of the method called, e.g. "VerifyAppToken"
+	Method string
+
+	// Request is the request struct passed to the token, revoke, migration and session methods
+	Request interface{}
+
+	// Token is the id_token passed to VerifyIDToken or the payload passed to ParseServerNotification
+	Token string
+
+	// ClientID is the client ID passed to VerifyIDToken
+	ClientID string
+}
+
+type fakeResponse struct {
+	body []byte
+	err  error
+}
+
+// FakeClient is an in-memory implementation of API for tests. Responses are scripted per method with
+// Respond and every call is recorded for later assertions. It is safe for concurrent use.
+type FakeClient struct {
+	mu        sync.Mutex
+	responses map[string][]fakeResponse
+	calls     []FakeCall
+}
+
+// NewFakeClient creates a FakeClient with no scripted responses
+func NewFakeClient() *FakeClient {
+	return &FakeClient{responses: make(map[string][]fakeResponse)}
+}
+
+// Respond scripts the next response of method, which is named as on API, e.g. "VerifyRefreshToken".
+//
+// response is encoded to JSON and decoded into the caller's result, so it may be the library's
+// response type, a map or a raw JSON string. A nil response leaves the result untouched. err, when set,
+// is returned from the call. Responses are used in the order they were scripted and the last one is
+// repeated for further calls. Respond panics on an unknown method name.
+func (f *FakeClient) Respond(method string, response interface{}, err error) *FakeClient {
+	if !fakeMethods[method] {
+		panic(fmt.Sprintf("apple.FakeClient: unknown method %q", method))
+	}
+
+	var body []byte
+	switch r := response.(type) {
+	case nil:
+	case string:
+		body = []byte(r)
+	case []byte:
+		body = r
+	default:
+		var marshalErr error
+		body, marshalErr = json.Marshal(r)
+		if marshalErr != nil {
+			panic(fmt.Sprintf("apple.FakeClient: encoding response for %s: %v", method, marshalErr))
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[method] = append(f.responses[method], fakeResponse{body: body, err: err})
+	return f
+}
+
+// Calls returns every call made so far, in order
+func (f *FakeClient) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
+
+// CallsTo returns the calls made to method, in order
+func (f *FakeClient) CallsTo(method string) []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []FakeCall
+	for _, call := range f.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset discards all scripted responses and recorded calls
+func (f *FakeClient) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = make(map[string][]fakeResponse)
+	f.calls = nil
+}
+
+// VerifyWebToken records the call and returns the scripted response
+func (f *FakeClient) VerifyWebToken(ctx context.Context, reqBody WebValidationTokenRequest, result interface{}) error {
+	return f.call(FakeCall{Method: "VerifyWebToken", Request: reqBody}, result)
+}
+
+// VerifyAppToken records the call and returns the scripted response
+func (f *FakeClient) VerifyAppToken(ctx context.Context, reqBody AppValidationTokenRequest, result interface{}) error {
+	return f.call(FakeCall{Method: "VerifyAppToken", Request: reqBody}, result)
+}
+
+// VerifyRefreshToken records the call and returns the scripted response
+func (f *FakeClient) VerifyRefreshToken(ctx context.Context, reqBody ValidationRefreshRequest, result interface{}) error {
+	return f.call(FakeCall{Method: "VerifyRefreshToken", Request: reqBody}, result)
+}
+
+// RevokeAccessToken records the call and returns the scripted response
+func (f *FakeClient) RevokeAccessToken(ctx context.Context, reqBody RevokeAccessTokenRequest, result interface{}) error {
+	return f.call(FakeCall{Method: "RevokeAccessToken", Request: reqBody}, result)
+}
+
+// RevokeRefreshToken records the call and returns the scripted response
+func (f *FakeClient) RevokeRefreshToken(ctx context.Context, reqBody RevokeRefreshTokenRequest, result interface{}) error {
+	return f.call(FakeCall{Method: "RevokeRefreshToken", Request: reqBody}, result)
+}
+
+// GetUserMigrationInfo records the call and returns the scripted response
+func (f *FakeClient) GetUserMigrationInfo(ctx context.Context, req UserMigrationRequest, resp *UserMigrationResponse) error {
+	return f.call(FakeCall{Method: "GetUserMigrationInfo", Request: req}, resp)
+}
+
+// VerifyIDToken records the call and returns the scripted claims
+func (f *FakeClient) VerifyIDToken(ctx context.Context, idToken, clientID string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	if err := f.call(FakeCall{Method: "VerifyIDToken", Token: idToken, ClientID: clientID}, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ParseServerNotification records the call and returns the scripted claims
+func (f *FakeClient) ParseServerNotification(ctx context.Context, jwtPayload string) (*ServerNotificationClaims, error) {
+	claims := &ServerNotificationClaims{}
+	if err := f.call(FakeCall{Method: "ParseServerNotification", Token: jwtPayload}, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Exchange records the call and returns the scripted session
+func (f *FakeClient) Exchange(ctx context.Context, req ExchangeRequest) (*Session, error) {
+	return f.session(FakeCall{Method: "Exchange", Request: req})
+}
+
+// Refresh records the call and returns the scripted session
+func (f *FakeClient) Refresh(ctx context.Context, req RefreshRequest) (*Session, error) {
+	return f.session(FakeCall{Method: "Refresh", Request: req})
+}
+
+// RevokeUser records the call and revokes the tokens through RevokeRefreshToken and RevokeAccessToken,
+// so their scripted responses decide the result, as they do for the package-level RevokeUser
+func (f *FakeClient) RevokeUser(ctx context.Context, req RevokeUserRequest) *RevokeUserResult {
+	f.mu.Lock()
+	f.calls = append(f.calls, FakeCall{Method: "RevokeUser", Request: req})
+	f.mu.Unlock()
+	return RevokeUser(ctx, f, req)
+}
+
+// session records call and returns the scripted session of its method
+func (f *FakeClient) session(call FakeCall) (*Session, error) {
+	session := &Session{}
+	if err := f.call(call, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// call records call and decodes the next scripted response of its method into result
+func (f *FakeClient) call(call FakeCall, result interface{}) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	queue := f.responses[call.Method]
+	var resp fakeResponse
+	found := len(queue) > 0
+	if found {
+		resp = queue[0]
+		if len(queue) > 1 {
+			f.responses[call.Method] = queue[1:]
+		}
+	}
+	f.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrUnscriptedCall, call.Method)
+	}
+	if resp.body != nil {
+		if err := json.Unmarshal(resp.body, result); err != nil {
+			return fmt.Errorf("apple.FakeClient: decoding scripted %s response into %T: %w", call.Method, result, err)
+		}
+	}
+	return resp.err
+}
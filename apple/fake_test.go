@@ -0,0 +1,122 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ API              = (*Client)(nil)
+	_ API              = (*FakeClient)(nil)
+	_ ValidationClient = (*FakeClient)(nil)
+	_ SessionExchanger = (*FakeClient)(nil)
+)
+
+func TestFakeClientScriptedResponses(t *testing.T) {
+	f := NewFakeClient().
+		Respond("VerifyAppToken", ValidationResponse{AccessToken: "first"}, nil).
+		Respond("VerifyAppToken", `{"error":"invalid_grant"}`, nil)
+
+	req := AppValidationTokenRequest{ClientID: "cid", ClientSecret: "s", Code: "c"}
+	var resp ValidationResponse
+	require.NoError(t, f.VerifyAppToken(context.Background(), req, &resp))
+	assert.Equal(t, "first", resp.AccessToken)
+
+	for i := 0; i < 2; i++ {
+		resp = ValidationResponse{}
+		require.NoError(t, f.VerifyAppToken(context.Background(), req, &resp))
+		assert.Equal(t, "invalid_grant", resp.Error, "the last response repeats")
+	}
+
+	calls := f.CallsTo("VerifyAppToken")
+	require.Len(t, calls, 3)
+	assert.Equal(t, req, calls[0].Request)
+}
+
+func TestFakeClientErrorsAndClaims(t *testing.T) {
+	boom := errors.New("boom")
+	f := NewFakeClient().
+		Respond("RevokeRefreshToken", nil, boom).
+		Respond("VerifyIDToken", IDTokenClaims{Subject: "user123", EmailVerified: true}, nil).
+		Respond("ParseServerNotification", map[string]interface{}{"events": map[string]string{"type": "account-delete", "sub": "user123"}}, nil)
+
+	var revoke RevokeResponse
+	assert.ErrorIs(t, f.RevokeRefreshToken(context.Background(), RevokeRefreshTokenRequest{RefreshToken: "r"}, &revoke), boom)
+
+	claims, err := f.VerifyIDToken(context.Background(), "id-token", "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, "user123", claims.Subject)
+	assert.True(t, claims.EmailVerified)
+
+	notification, err := f.ParseServerNotification(context.Background(), "payload")
+	require.NoError(t, err)
+	assert.Equal(t, "account-delete", notification.Events.Type)
+
+	_, err = f.VerifyIDToken(context.Background(), "other", "com.example.app")
+	require.NoError(t, err)
+
+	var migration UserMigrationResponse
+	assert.ErrorIs(t, f.GetUserMigrationInfo(context.Background(), UserMigrationRequest{}, &migration), ErrUnscriptedCall)
+
+	calls := f.Calls()
+	require.Len(t, calls, 5)
+	assert.Equal(t, FakeCall{Method: "VerifyIDToken", Token: "id-token", ClientID: "com.example.app"}, calls[1])
+	assert.Equal(t, "payload", calls[2].Token)
+
+	f.Reset()
+	assert.Empty(t, f.Calls())
+	_, err = f.VerifyIDToken(context.Background(), "id-token", "com.example.app")
+	assert.ErrorIs(t, err, ErrUnscriptedCall)
+}
+
+func TestFakeClientSessions(t *testing.T) {
+	f := NewFakeClient().
+		Respond("Exchange", Session{RefreshToken: "r", Claims: &IDTokenClaims{Subject: "user123"}}, nil).
+		Respond("Refresh", nil, ErrSubjectMismatch).
+		Respond("RevokeRefreshToken", nil, nil).
+		Respond("RevokeAccessToken", RevokeResponse{Error: "invalid_grant"}, nil)
+
+	var exchanger SessionExchanger = f
+	session, err := exchanger.Exchange(context.Background(), ExchangeRequest{ClientID: "cid", Code: "code"})
+	require.NoError(t, err)
+	assert.Equal(t, "r", session.RefreshToken)
+	assert.Equal(t, "user123", session.Claims.Subject)
+
+	_, err = exchanger.Refresh(context.Background(), RefreshRequest{ClientID: "cid", RefreshToken: "r", Subject: "user123"})
+	assert.ErrorIs(t, err, ErrSubjectMismatch)
+
+	result := exchanger.RevokeUser(context.Background(), RevokeUserRequest{ClientID: "cid", RefreshToken: "r", AccessToken: "a"})
+	assert.True(t, result.Complete())
+	assert.True(t, result.AccessToken.AlreadyInvalid)
+
+	calls := f.Calls()
+	require.Len(t, calls, 5)
+	assert.Equal(t, FakeCall{Method: "Exchange", Request: ExchangeRequest{ClientID: "cid", Code: "code"}}, calls[0])
+	assert.Equal(t, "RevokeUser", calls[2].Method)
+	assert.Equal(t, "RevokeRefreshToken", calls[3].Method)
+}
+
+func TestFakeClientRespondUnknownMethod(t *testing.T) {
+	assert.Panics(t, func() { NewFakeClient().Respond("VerifyToken", nil, nil) })
+}
+
+func TestFakeClientConcurrentUse(t *testing.T) {
+	f := NewFakeClient().Respond("VerifyRefreshToken", RefreshResponse{AccessToken: "a"}, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp RefreshResponse
+			assert.NoError(t, f.VerifyRefreshToken(context.Background(), ValidationRefreshRequest{}, &resp))
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, f.CallsTo("VerifyRefreshToken"), 10)
+}
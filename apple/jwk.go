@@ -0,0 +1,238 @@
+package apple
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrUnsupportedKeyType is returned when a JWK uses a key type or curve that this package cannot convert.
+var ErrUnsupportedKeyType = errors.New("unsupported JWK key type")
+
+// JWK is a single JSON Web Key as defined by RFC 7517. Only the members needed for
+// RSA and EC P-256 public keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA public key members
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC public key members
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set such as the one served at https://appleid.apple.com/auth/keys.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParseJWKS decodes a JSON Web Key Set.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+	return &set, nil
+}
+
+// Marshal serializes the key set to JSON.
+func (s *JWKS) Marshal() ([]byte, error) {
+	keys := s.Keys
+	if keys == nil {
+		keys = []JWK{}
+	}
+	return json.Marshal(JWKS{Keys: keys})
+}
+
+// Key returns the key with the given kid.
+func (s *JWKS) Key(kid string) (JWK, bool) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return JWK{}, false
+}
+
+// Filter returns the keys whose use and alg match the given values. A key that omits
+// use or alg matches any value, as permitted by RFC 7517. An empty argument matches every key.
+func (s *JWKS) Filter(use, alg string) *JWKS {
+	out := &JWKS{Keys: []JWK{}}
+	for _, k := range s.Keys {
+		if use != "" && k.Use != "" && k.Use != use {
+			continue
+		}
+		if alg != "" && k.Alg != "" && k.Alg != alg {
+			continue
+		}
+		out.Keys = append(out.Keys, k)
+	}
+	return out
+}
+
+// SigningKeys returns the keys usable for signature verification (use=sig, or no use given).
+func (s *JWKS) SigningKeys() *JWKS {
+	return s.Filter("sig", "")
+}
+
+// NewJWK builds a JWK from an *rsa.PublicKey or a P-256 *ecdsa.PublicKey.
+// The alg defaults to RS256 or ES256 respectively when empty.
+func NewJWK(pub crypto.PublicKey, kid, alg string) (JWK, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if alg == "" {
+			alg = "RS256"
+		}
+		return JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return JWK{}, fmt.Errorf("%w: curve %s", ErrUnsupportedKeyType, key.Curve.Params().Name)
+		}
+		if alg == "" {
+			alg = "ES256"
+		}
+		return JWK{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}, nil
+	}
+	return JWK{}, fmt.Errorf("%w: %T", ErrUnsupportedKeyType, pub)
+}
+
+// PublicKey converts the JWK into an *rsa.PublicKey or *ecdsa.PublicKey.
+// Keys whose type, curve or alg this package does not handle, such as PS256 or ES384, return an
+// error wrapping ErrUnsupportedKeyType so callers can skip them; other errors mean a malformed key.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		if k.Alg != "" && k.Alg != "RS256" && k.Alg != "RS384" && k.Alg != "RS512" {
+			return nil, fmt.Errorf("%w: alg %q for an RSA key", ErrUnsupportedKeyType, k.Alg)
+		}
+		return k.rsaPublicKey()
+	case "EC":
+		if k.Alg != "" && k.Alg != "ES256" {
+			return nil, fmt.Errorf("%w: alg %q for an EC key", ErrUnsupportedKeyType, k.Alg)
+		}
+		return k.ecPublicKey()
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedKeyType, k.Kty)
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint: the base64url-encoded SHA-256 hash
+// of the key's required members serialized in lexicographic order.
+func (k JWK) Thumbprint() (string, error) {
+	var members []string
+	switch k.Kty {
+	case "RSA":
+		if k.N == "" || k.E == "" {
+			return "", fmt.Errorf("RSA JWK is missing n or e")
+		}
+		members = []string{"e", k.E, "kty", k.Kty, "n", k.N}
+	case "EC":
+		if k.Crv == "" || k.X == "" || k.Y == "" {
+			return "", fmt.Errorf("EC JWK is missing crv, x or y")
+		}
+		members = []string{"crv", k.Crv, "kty", k.Kty, "x", k.X, "y", k.Y}
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedKeyType, k.Kty)
+	}
+
+	// RFC 7638 section 3.3 requires no whitespace and members sorted by name
+	buf := []byte{'{'}
+	for i := 0; i < len(members); i += 2 {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(members[i])
+		value, _ := json.Marshal(members[i+1])
+		buf = append(buf, name...)
+		buf = append(buf, ':')
+		buf = append(buf, value...)
+	}
+	buf = append(buf, '}')
+
+	sum := sha256.Sum256(buf)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (k JWK) rsaPublicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	if len(nBytes) == 0 {
+		return nil, fmt.Errorf("invalid modulus: empty")
+	}
+
+	// Reject exponents that would overflow an int rather than silently truncating them,
+	// and those that cannot form a valid RSA key (even or smaller than 3).
+	e := new(big.Int).SetBytes(eBytes)
+	if e.BitLen() > 31 {
+		return nil, fmt.Errorf("invalid exponent: too large")
+	}
+	if e.Int64() < 3 || e.Bit(0) == 0 {
+		return nil, fmt.Errorf("invalid exponent: %d", e.Int64())
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: int(e.Int64())}, nil
+}
+
+func (k JWK) ecPublicKey() (*ecdsa.PublicKey, error) {
+	if k.Crv != "P-256" {
+		return nil, fmt.Errorf("%w: curve %q", ErrUnsupportedKeyType, k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate: %w", err)
+	}
+	if len(x) != 32 || len(y) != 32 {
+		return nil, fmt.Errorf("invalid P-256 coordinates: expected 32 bytes each")
+	}
+
+	// crypto/ecdh rejects points that are not on the curve
+	point := append([]byte{4}, x...)
+	point = append(point, y...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid P-256 point: %w", err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
@@ -0,0 +1,204 @@
+package apple
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// Example key and expected thumbprint from RFC 7638 section 3.1
+	key := JWK{
+		Kty: "RSA",
+		Kid: "2011-04-29",
+		Alg: "RS256",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+
+	thumbprint, err := key.Thumbprint()
+	require.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+
+	// Optional members must not affect the thumbprint
+	key.Kid = "other"
+	key.Use = "sig"
+	other, err := key.Thumbprint()
+	require.NoError(t, err)
+	assert.Equal(t, thumbprint, other)
+
+	_, err = JWK{Kty: "oct"}.Thumbprint()
+	assert.ErrorIs(t, err, ErrUnsupportedKeyType)
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	t.Run("rsa", func(t *testing.T) {
+		jwk, err := NewJWK(&rsaKey.PublicKey, "rsa-kid", "")
+		require.NoError(t, err)
+		assert.Equal(t, "RS256", jwk.Alg)
+
+		pub, err := jwk.PublicKey()
+		require.NoError(t, err)
+		assert.True(t, rsaKey.PublicKey.Equal(pub))
+	})
+
+	t.Run("ec p-256", func(t *testing.T) {
+		jwk, err := NewJWK(&ecKey.PublicKey, "ec-kid", "")
+		require.NoError(t, err)
+		assert.Equal(t, "ES256", jwk.Alg)
+		assert.Equal(t, "P-256", jwk.Crv)
+
+		pub, err := jwk.PublicKey()
+		require.NoError(t, err)
+		assert.True(t, ecKey.PublicKey.Equal(pub))
+
+		thumbprint, err := jwk.Thumbprint()
+		require.NoError(t, err)
+		assert.NotEmpty(t, thumbprint)
+	})
+
+	t.Run("unsupported curve", func(t *testing.T) {
+		p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+		_, err = NewJWK(&p384.PublicKey, "kid", "")
+		assert.ErrorIs(t, err, ErrUnsupportedKeyType)
+	})
+}
+
+func TestJWKPublicKeyValidation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	good, err := NewJWK(&rsaKey.PublicKey, "kid", "")
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	goodEC, err := NewJWK(&ecKey.PublicKey, "kid", "")
+	require.NoError(t, err)
+
+	encodeInt := func(v *big.Int) string { return base64.RawURLEncoding.EncodeToString(v.Bytes()) }
+
+	tests := []struct {
+		name    string
+		modify  func(k JWK) JWK
+		wantErr bool
+	}{
+		{name: "valid rsa", modify: func(k JWK) JWK { return k }},
+		{
+			name:    "even exponent",
+			modify:  func(k JWK) JWK { k.E = encodeInt(big.NewInt(65536)); return k },
+			wantErr: true,
+		},
+		{
+			name:    "exponent of one",
+			modify:  func(k JWK) JWK { k.E = "AQ"; return k },
+			wantErr: true,
+		},
+		{
+			name: "exponent larger than an int",
+			modify: func(k JWK) JWK {
+				k.E = encodeInt(new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 64), big.NewInt(1)))
+				return k
+			},
+			wantErr: true,
+		},
+		{
+			name:    "rsa key with ec alg",
+			modify:  func(k JWK) JWK { k.Alg = "ES256"; return k },
+			wantErr: true,
+		},
+		{
+			name:    "bad modulus encoding",
+			modify:  func(k JWK) JWK { k.N = "!!!"; return k },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.modify(good).PublicKey()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("ec point not on curve", func(t *testing.T) {
+		k := goodEC
+		k.Y = k.X
+		_, err := k.PublicKey()
+		assert.Error(t, err)
+	})
+
+	t.Run("ec key with rsa alg", func(t *testing.T) {
+		k := goodEC
+		k.Alg = "RS256"
+		_, err := k.PublicKey()
+		assert.ErrorIs(t, err, ErrUnsupportedKeyType)
+	})
+
+	t.Run("unsupported algs are not malformed keys", func(t *testing.T) {
+		rsaPSS := good
+		rsaPSS.Alg = "PS256"
+		_, err := rsaPSS.PublicKey()
+		assert.ErrorIs(t, err, ErrUnsupportedKeyType)
+
+		p384 := goodEC
+		p384.Alg = "ES384"
+		p384.Crv = "P-384"
+		_, err = p384.PublicKey()
+		assert.ErrorIs(t, err, ErrUnsupportedKeyType)
+	})
+}
+
+func TestJWKSParseFilterAndMarshal(t *testing.T) {
+	body := []byte(`{"keys":[
+		{"kty":"RSA","kid":"sig-rs","use":"sig","alg":"RS256","n":"AQAB","e":"AQAB"},
+		{"kty":"RSA","kid":"enc-rs","use":"enc","alg":"RSA-OAEP","n":"AQAB","e":"AQAB"},
+		{"kty":"EC","kid":"sig-ec","use":"sig","alg":"ES256","crv":"P-256","x":"AA","y":"AA"},
+		{"kty":"RSA","kid":"no-use","n":"AQAB","e":"AQAB"}
+	]}`)
+
+	set, err := ParseJWKS(body)
+	require.NoError(t, err)
+	require.Len(t, set.Keys, 4)
+
+	signing := set.SigningKeys()
+	assert.Len(t, signing.Keys, 3)
+	_, found := signing.Key("enc-rs")
+	assert.False(t, found, "keys with use=enc should be filtered out")
+
+	rs256 := set.Filter("sig", "RS256")
+	assert.Len(t, rs256.Keys, 2)
+
+	key, found := set.Key("sig-ec")
+	require.True(t, found)
+	assert.Equal(t, "P-256", key.Crv)
+
+	out, err := signing.Marshal()
+	require.NoError(t, err)
+	reparsed, err := ParseJWKS(out)
+	require.NoError(t, err)
+	assert.Equal(t, signing.Keys, reparsed.Keys)
+
+	empty, err := (&JWKS{}).Marshal()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"keys":[]}`, string(empty))
+
+	_, err = ParseJWKS([]byte("not json"))
+	assert.Error(t, err)
+}
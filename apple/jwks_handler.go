@@ -0,0 +1,154 @@
+package apple
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWKSRefreshBackoff is how long JWKSHandler waits after a failed refresh before asking Apple again
+const JWKSRefreshBackoff = 30 * time.Second
+
+// JWKSHandler returns an http.Handler that re-serves Apple's JWKS from the client's key cache.
+//
+// It lets services that cannot use this package verify Apple tokens against a single,
+// controlled endpoint instead of each fetching https://appleid.apple.com/auth/keys. The key set is
+// refreshed from Apple when the cache is older than JWKSCacheTTL, and responses carry an ETag
+// and a Cache-Control max-age matching the time left until the next refresh, so downstream
+// caches never hold keys longer than this client does.
+//
+// Concurrent requests share a single refresh, and after a failed refresh Apple is not asked again
+// for JWKSRefreshBackoff, so an outage at Apple does not turn every request into an upstream call.
+// Meanwhile, if a previously fetched key set is available, the stale set is served with max-age=0.
+// If no key set has ever been fetched the handler responds 502.
+func (c *Client) JWKSHandler() http.Handler {
+	return &jwksHandler{client: c}
+}
+
+type jwksHandler struct {
+	client *Client
+}
+
+func (h *jwksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	c := h.client
+	cfg := c.config.Load()
+	refreshErr := c.refreshJWKSIfStale(r.Context(), cfg)
+
+	c.jwksMu.RLock()
+	set := c.jwksSet
+	fetchedAt := c.jwksFetchedAt
+	c.jwksMu.RUnlock()
+
+	if set == nil {
+		http.Error(w, "Apple JWKS unavailable", http.StatusBadGateway)
+		return
+	}
+
+	body, err := set.Marshal()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+
+	maxAge := int64(0)
+	if refreshErr == nil {
+		if remaining := cfg.jwksCacheTTL - cfg.clock.Now().Sub(fetchedAt); remaining > 0 {
+			maxAge = int64(remaining / time.Second)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, must-revalidate", maxAge))
+	w.Header().Set("Last-Modified", fetchedAt.UTC().Format(http.TimeFormat))
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
+
+// jwksRefreshCall is a key set refresh shared by every request that finds the cache stale
+type jwksRefreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// refreshJWKSIfStale refreshes the key set when it has never been fetched or has outlived its TTL.
+// Callers join the refresh in flight, if any, and within JWKSRefreshBackoff of a failed refresh they
+// get its error without contacting Apple. The shared refresh runs detached from ctx, bounded by the
+// HTTP client's timeout, so a caller giving up does not cancel it for the others.
+func (c *Client) refreshJWKSIfStale(ctx context.Context, cfg *clientConfig) error {
+	c.jwksMu.RLock()
+	stale := c.jwksSet == nil || cfg.clock.Now().Sub(c.jwksFetchedAt) > cfg.jwksCacheTTL
+	c.jwksMu.RUnlock()
+
+	if !stale {
+		return nil
+	}
+
+	c.jwksRefreshMu.Lock()
+	if c.jwksRefreshErr != nil && cfg.clock.Now().Sub(c.jwksRefreshFailedAt) < JWKSRefreshBackoff {
+		err := c.jwksRefreshErr
+		c.jwksRefreshMu.Unlock()
+		return err
+	}
+	call := c.jwksRefresh
+	if call == nil {
+		call = &jwksRefreshCall{done: make(chan struct{})}
+		c.jwksRefresh = call
+		go c.runJWKSRefresh(context.WithoutCancel(ctx), cfg, call)
+	}
+	c.jwksRefreshMu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runJWKSRefresh performs call and records its failure for the backoff
+func (c *Client) runJWKSRefresh(ctx context.Context, cfg *clientConfig, call *jwksRefreshCall) {
+	_, call.err = c.refreshJWKS(ctx, cfg)
+
+	c.jwksRefreshMu.Lock()
+	c.jwksRefresh = nil
+	c.jwksRefreshErr = call.err
+	c.jwksRefreshFailedAt = cfg.clock.Now()
+	c.jwksRefreshMu.Unlock()
+	close(call.done)
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, honouring lists and "*".
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
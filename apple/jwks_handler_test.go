@@ -0,0 +1,155 @@
+package apple
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSHandler(t *testing.T) {
+	_, jwksHandler := generateTestKey(t)
+
+	var callCount atomic.Int32
+	var fail atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		jwksHandler(w, r)
+	}))
+	defer srv.Close()
+
+	c := NewWithOptions(ClientOptions{
+		AppleKeysURL: srv.URL,
+		JWKSCacheTTL: time.Hour,
+	})
+	handler := c.JWKSHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/keys", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Cache-Control"), "max-age=")
+	assert.NotContains(t, rec.Header().Get("Cache-Control"), "max-age=0,")
+	assert.Equal(t, int32(1), callCount.Load())
+
+	set, err := ParseJWKS(rec.Body.Bytes())
+	require.NoError(t, err)
+	_, found := set.Key(testKID)
+	assert.True(t, found, "mirrored key set should contain Apple's key")
+
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	t.Run("served from cache while fresh", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/keys", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, etag, rec.Header().Get("ETag"))
+		assert.Equal(t, int32(1), callCount.Load())
+	})
+
+	t.Run("matching If-None-Match returns 304", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/auth/keys", nil)
+		req.Header.Set("If-None-Match", `"other", `+etag)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.Bytes())
+	})
+
+	t.Run("HEAD returns headers without a body", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/auth/keys", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, etag, rec.Header().Get("ETag"))
+		assert.Empty(t, rec.Body.Bytes())
+	})
+
+	t.Run("other methods are rejected", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/keys", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		assert.Equal(t, "GET, HEAD", rec.Header().Get("Allow"))
+	})
+
+	t.Run("stale keys are served with max-age=0 when Apple is unavailable", func(t *testing.T) {
+		fail.Store(true)
+		defer fail.Store(false)
+
+		c.jwksMu.Lock()
+		c.jwksFetchedAt = time.Now().Add(-2 * time.Hour)
+		c.jwksMu.Unlock()
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/keys", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "public, max-age=0, must-revalidate", rec.Header().Get("Cache-Control"))
+		assert.Equal(t, etag, rec.Header().Get("ETag"))
+	})
+}
+
+func TestJWKSHandlerUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := NewWithOptions(ClientOptions{AppleKeysURL: srv.URL})
+	rec := httptest.NewRecorder()
+	c.JWKSHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/keys", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+}
+
+func TestJWKSHandlerCoalescesRefreshes(t *testing.T) {
+	var callCount atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount.Add(1)
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Now())
+	c := NewWithOptions(ClientOptions{AppleKeysURL: srv.URL, Clock: clock})
+	handler := c.JWKSHandler()
+
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/keys", nil))
+			codes[i] = rec.Code
+		}(i)
+	}
+	require.Eventually(t, func() bool { return callCount.Load() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), callCount.Load(), "concurrent requests share one refresh")
+	for _, code := range codes {
+		assert.Equal(t, http.StatusBadGateway, code)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/keys", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "Apple JWKS unavailable\n", rec.Body.String(), "the upstream error is not exposed")
+	assert.Equal(t, int32(1), callCount.Load(), "Apple is not asked again during the backoff")
+
+	clock.Advance(JWKSRefreshBackoff)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/auth/keys", nil))
+	assert.Equal(t, int32(2), callCount.Load(), "the refresh is retried after the backoff")
+}
@@ -0,0 +1,169 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrAudienceNotAccepted is passed to MiddlewareOptions.OnError when a token's aud is not one of Audiences
+var ErrAudienceNotAccepted = errors.New("id_token audience not accepted")
+
+// errNoToken is reported when a request carries no id_token
+var errNoToken = errors.New("no id_token in request")
+
+type claimsContextKey struct{}
+
+// ContextWithClaims returns a copy of ctx carrying claims, as the middleware does. Use it to test
+// handlers without a token.
+func ContextWithClaims(ctx context.Context, claims *IDTokenClaims) context.Context {
+	return context.WithValue(ctx, claimsContextKey{}, claims)
+}
+
+// ClaimsFromContext returns the verified claims stored by the middleware, and false when the request
+// was not authenticated
+func ClaimsFromContext(ctx context.Context) (*IDTokenClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*IDTokenClaims)
+	return claims, ok && claims != nil
+}
+
+// MiddlewareOptions configures the middleware returned by NewMiddleware
+type MiddlewareOptions struct {
+	// Verifier verifies the tokens, usually a *Client
+	Verifier IDTokenVerifier
+
+	// Audiences are the client IDs whose tokens are accepted, e.g. the app's bundle ID and Services ID
+	Audiences []string
+
+	// CookieName is the cookie read when the request has no Authorization header. Leave it empty to
+	// accept only the header.
+	CookieName string
+
+	// Realm is sent in the WWW-Authenticate challenge. Optional.
+	Realm string
+
+	// Optional lets requests without any token through unauthenticated, so handlers can decide with
+	// ClaimsFromContext. Requests with an invalid token are still rejected.
+	Optional bool
+
+	// OnError is called with the reason a request was rejected, for logging. The response itself only
+	// carries a generic description.
+	OnError func(r *http.Request, err error)
+}
+
+// NewMiddleware returns net/http middleware that authenticates requests bearing an Apple id_token.
+//
+// The token is read from an "Authorization: Bearer" header, or from CookieName, and verified with
+// VerifyIDToken against the audience it names, which must be one of Audiences. Handlers read the
+// claims with ClaimsFromContext. Failures are answered as RFC 6750 describes: 401 with a Bearer
+// challenge when the token is missing, 401 with error="invalid_token" when it does not verify, and
+// 400 with error="invalid_request" when the Authorization header is malformed.
+func NewMiddleware(options MiddlewareOptions) (func(http.Handler) http.Handler, error) {
+	if options.Verifier == nil {
+		return nil, errors.New("verifier is required")
+	}
+	if len(options.Audiences) == 0 {
+		return nil, errors.New("at least one audience is required")
+	}
+	audiences := make(map[string]bool, len(options.Audiences))
+	for _, aud := range options.Audiences {
+		audiences[aud] = true
+	}
+
+	m := &middleware{options: options, audiences: audiences}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.serve(w, r, next)
+		})
+	}, nil
+}
+
+type middleware struct {
+	options   MiddlewareOptions
+	audiences map[string]bool
+}
+
+func (m *middleware) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	token, err := m.token(r)
+	if err != nil {
+		m.reject(w, r, http.StatusBadRequest, "invalid_request", "malformed Authorization header", err)
+		return
+	}
+	if token == "" {
+		if m.options.Optional {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m.reject(w, r, http.StatusUnauthorized, "", "", errNoToken)
+		return
+	}
+
+	claims, err := m.verify(r.Context(), token)
+	if err != nil {
+		m.reject(w, r, http.StatusUnauthorized, "invalid_token", "the id_token is invalid or expired", err)
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+}
+
+// token returns the id_token of r, or "" when it has none. Authorization headers using another
+// scheme are not ours and count as no token.
+func (m *middleware) token(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return "", nil
+		}
+		token = strings.TrimSpace(token)
+		if token == "" || strings.ContainsAny(token, " \t") {
+			return "", errors.New("bearer token missing or malformed")
+		}
+		return token, nil
+	}
+
+	if m.options.CookieName != "" {
+		if cookie, err := r.Cookie(m.options.CookieName); err == nil {
+			return cookie.Value, nil
+		}
+	}
+	return "", nil
+}
+
+// verify checks token against the audience it claims, once that audience is known to be accepted
+func (m *middleware) verify(ctx context.Context, token string) (*IDTokenClaims, error) {
+	unverified, err := GetTypedClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	if !m.audiences[unverified.Audience] {
+		return nil, fmt.Errorf("%w: %q", ErrAudienceNotAccepted, unverified.Audience)
+	}
+	return m.options.Verifier.VerifyIDToken(ctx, token, unverified.Audience)
+}
+
+// reject answers with status and an RFC 6750 challenge. code and description are omitted when empty.
+func (m *middleware) reject(w http.ResponseWriter, r *http.Request, status int, code, description string, err error) {
+	if m.options.OnError != nil {
+		m.options.OnError(r, err)
+	}
+
+	var params []string
+	if m.options.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", m.options.Realm))
+	}
+	if code != "" {
+		params = append(params, fmt.Sprintf("error=%q", code))
+	}
+	if description != "" {
+		params = append(params, fmt.Sprintf("error_description=%q", description))
+	}
+	challenge := "Bearer"
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,168 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+	jwksSrv := httptest.NewServer(jwksHandler)
+	defer jwksSrv.Close()
+	client := NewWithOptions(ClientOptions{AppleKeysURL: jwksSrv.URL})
+
+	var rejections []error
+	mw, err := NewMiddleware(MiddlewareOptions{
+		Verifier:   client,
+		Audiences:  []string{"com.example.app", "com.example.web"},
+		CookieName: "apple_id_token",
+		Realm:      "api",
+		OnError:    func(r *http.Request, err error) { rejections = append(rejections, err) },
+	})
+	require.NoError(t, err)
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		require.True(t, ok)
+		w.Write([]byte(claims.Subject + " " + claims.Audience))
+	}))
+
+	token := func(aud string, exp time.Duration) string {
+		return makeIDToken(t, privKey, jwt.MapClaims{
+			"iss": AppleIssuer,
+			"aud": aud,
+			"sub": "user123",
+			"iat": float64(time.Now().Unix()),
+			"exp": float64(time.Now().Add(exp).Unix()),
+		})
+	}
+
+	tests := []struct {
+		name       string
+		setup      func(r *http.Request)
+		wantStatus int
+		wantBody   string
+		wantHeader string
+	}{
+		{
+			name:       "bearer header",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token("com.example.app", time.Hour)) },
+			wantStatus: http.StatusOK,
+			wantBody:   "user123 com.example.app",
+		},
+		{
+			name: "cookie with second audience",
+			setup: func(r *http.Request) {
+				r.AddCookie(&http.Cookie{Name: "apple_id_token", Value: token("com.example.web", time.Hour)})
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "user123 com.example.web",
+		},
+		{
+			name:       "no token",
+			setup:      func(r *http.Request) {},
+			wantStatus: http.StatusUnauthorized,
+			wantHeader: `Bearer realm="api"`,
+		},
+		{
+			name:       "other scheme",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Basic dXNlcjpwYXNz") },
+			wantStatus: http.StatusUnauthorized,
+			wantHeader: `Bearer realm="api"`,
+		},
+		{
+			name:       "empty bearer",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer ") },
+			wantStatus: http.StatusBadRequest,
+			wantHeader: `Bearer realm="api", error="invalid_request", error_description="malformed Authorization header"`,
+		},
+		{
+			name:       "expired",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token("com.example.app", -time.Hour)) },
+			wantStatus: http.StatusUnauthorized,
+			wantHeader: `Bearer realm="api", error="invalid_token", error_description="the id_token is invalid or expired"`,
+		},
+		{
+			name:       "audience not accepted",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token("com.other.app", time.Hour)) },
+			wantStatus: http.StatusUnauthorized,
+			wantHeader: `Bearer realm="api", error="invalid_token", error_description="the id_token is invalid or expired"`,
+		},
+		{
+			name:       "garbage",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer not-a-jwt") },
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/me", nil)
+			tt.setup(r)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+			if tt.wantHeader != "" {
+				assert.Equal(t, tt.wantHeader, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	require.NotEmpty(t, rejections)
+	assert.True(t, errors.Is(rejections[len(rejections)-2], ErrAudienceNotAccepted))
+}
+
+func TestMiddlewareOptional(t *testing.T) {
+	fake := NewFakeClient().Respond("VerifyIDToken", IDTokenClaims{Subject: "user123"}, nil)
+	mw, err := NewMiddleware(MiddlewareOptions{Verifier: fake, Audiences: []string{"com.example.app"}, Optional: true})
+	require.NoError(t, err)
+
+	var authenticated bool
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, authenticated = ClaimsFromContext(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, authenticated)
+	assert.Empty(t, fake.Calls())
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"aud": "com.example.app"})
+	token, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "bearer "+token)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.True(t, authenticated)
+	assert.Equal(t, FakeCall{Method: "VerifyIDToken", Token: token, ClientID: "com.example.app"}, fake.Calls()[0])
+}
+
+func TestNewMiddlewareValidation(t *testing.T) {
+	_, err := NewMiddleware(MiddlewareOptions{Audiences: []string{"com.example.app"}})
+	assert.Error(t, err)
+	_, err = NewMiddleware(MiddlewareOptions{Verifier: NewFakeClient()})
+	assert.Error(t, err)
+}
+
+func TestContextWithClaims(t *testing.T) {
+	_, ok := ClaimsFromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := ContextWithClaims(context.Background(), &IDTokenClaims{Subject: "user123"})
+	claims, ok := ClaimsFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "user123", claims.Subject)
+}
@@ -51,10 +51,19 @@ type UserMigrationResponse struct {
 	ErrorDescription string `json:"error_description"`
 }
 
+// Event types of a ServerNotificationPayload
+const (
+	// NotificationTypeConsentRevoked is sent when the user stops using Sign in with Apple with the app
+	NotificationTypeConsentRevoked = "consent-revoked"
+
+	// NotificationTypeAccountDelete is sent when the user deletes their Apple Account
+	NotificationTypeAccountDelete = "account-delete"
+)
+
 // ServerNotificationPayload is the event data embedded in Apple's server-to-server notification JWTs.
 // See https://developer.apple.com/documentation/technotes/tn3194-handling-account-deletions-and-revoking-tokens-for-sign-in-with-apple
 type ServerNotificationPayload struct {
-	// Type is the event type: "consent-revoked" or "account-delete"
+	// Type is the event type: NotificationTypeConsentRevoked or NotificationTypeAccountDelete
 	Type string `json:"type"`
 
 	// Sub is the user identifier affected by the event
@@ -182,6 +191,10 @@ type RefreshResponse struct {
 	// The amount of time, in seconds, before the access token expires. You can revalidate with this token
 	ExpiresIn int `json:"expires_in"`
 
+	// A JSON Web Token with the user's current identity information. Verify it with Client.Refresh or
+	// VerifyIDToken before trusting its claims.
+	IDToken string `json:"id_token"`
+
 	// Used to capture any error returned by the endpoint. Do not trust the response if this error is not nil
 	Error string `json:"error"`
 
@@ -0,0 +1,69 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSubjectMismatch is returned by Refresh when the refreshed id_token belongs to a different user
+var ErrSubjectMismatch = errors.New("id_token subject does not match the expected user")
+
+// RefreshRequest describes a refresh token to validate with Refresh
+type RefreshRequest struct {
+	// ClientID is the client ID the refresh token was issued to. The id_token's audience must match it.
+	ClientID string
+
+	// ClientSecret is the client secret. It may be left empty when ClientOptions.Secrets is set.
+	ClientSecret string
+
+	// RefreshToken is the refresh token received from a previous Exchange
+	RefreshToken string
+
+	// Subject is the user ID the refresh token was stored for. The sub claim of the refreshed id_token
+	// must equal it.
+	Subject string
+}
+
+// Refresh validates a refresh token with Apple and verifies the id_token returned with it.
+//
+// The id_token is verified like VerifyIDToken and its subject is compared with Subject, so a refresh
+// token stored against the wrong account is caught. The returned Session carries the given refresh
+// token, as Apple does not issue a new one. Apple errors are returned as *APIError; id_token problems
+// wrap ErrMissingIDToken, ErrInvalidIDToken or ErrSubjectMismatch.
+func (c *Client) Refresh(ctx context.Context, req RefreshRequest) (*Session, error) {
+	if req.ClientID == "" || req.RefreshToken == "" || req.Subject == "" {
+		return nil, errors.New("client ID, refresh token and subject are required")
+	}
+
+	requestedAt := c.config.Load().clock.Now()
+	resp, err := Send[RefreshResponse](ctx, c, ValidationRefreshRequest{
+		ClientID:     req.ClientID,
+		ClientSecret: req.ClientSecret,
+		RefreshToken: req.RefreshToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.IDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+	claims, err := c.VerifyIDToken(ctx, resp.IDToken, req.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+	if claims.Subject != req.Subject {
+		return nil, fmt.Errorf("%w: got %q", ErrSubjectMismatch, claims.Subject)
+	}
+
+	return &Session{
+		AccessToken:  resp.AccessToken,
+		TokenType:    resp.TokenType,
+		RefreshToken: req.RefreshToken,
+		ExpiresAt:    requestedAt.Add(time.Duration(resp.ExpiresIn) * time.Second),
+		IDToken:      resp.IDToken,
+		Claims:       claims,
+	}, nil
+}
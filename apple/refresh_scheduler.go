@@ -0,0 +1,204 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MinRefreshValidationInterval is the shortest interval between two validations of the same refresh
+// token. Apple asks that refresh tokens be validated no more than once a day.
+const MinRefreshValidationInterval = 24 * time.Hour
+
+// RefreshSchedulerOptions configures a RefreshScheduler
+type RefreshSchedulerOptions struct {
+	// Store holds the refresh tokens to validate
+	Store TokenStore
+
+	// Client validates the tokens, usually a *Client
+	Client TokenExchanger
+
+	// Secrets supplies client secrets. Leave it nil when Client already has ClientOptions.Secrets.
+	Secrets SecretProvider
+
+	// Interval is how long a token stays validated. Defaults to and must be at least MinRefreshValidationInterval.
+	Interval time.Duration
+
+	// Clock supplies the current time. Defaults to SystemClock.
+	Clock Clock
+
+	// OnRevoked is called for each token Apple reports as revoked, after it was deleted from Store.
+	// The user revoked access from their Apple ID settings or the token expired; sign them out.
+	OnRevoked func(ctx context.Context, token StoredToken)
+
+	// OnError is called when a token could not be validated, or could not be read from Store, in which
+	// case token only has Subject and ClientID and err is a *TokenDecryptionError. The token is retried
+	// on the next run.
+	OnError func(token StoredToken, err error)
+
+	// OnRunError is called by Run when a run fails as a whole, e.g. because Store could not be listed.
+	// The run is retried on the next tick.
+	OnRunError func(err error)
+}
+
+// RefreshRunResult counts what a RefreshScheduler run did
+type RefreshRunResult struct {
+	// Validated is the number of tokens Apple confirmed as valid
+	Validated int
+
+	// Revoked is the number of tokens Apple rejected with invalid_grant
+	Revoked int
+
+	// Failed is the number of tokens that could not be validated
+	Failed int
+
+	// Skipped is the number of tokens validated less than Interval ago
+	Skipped int
+}
+
+// RefreshScheduler validates stored refresh tokens at most once per Interval, so revoked tokens are
+// noticed without exceeding Apple's rate recommendations.
+type RefreshScheduler struct {
+	store     TokenStore
+	client    TokenExchanger
+	secrets   SecretProvider
+	interval  time.Duration
+	clock     Clock
+	onRevoked func(ctx context.Context, token StoredToken)
+	onError   func(token StoredToken, err error)
+	onRunErr  func(err error)
+}
+
+// NewRefreshScheduler creates a RefreshScheduler. Store and Client are required.
+func NewRefreshScheduler(options RefreshSchedulerOptions) (*RefreshScheduler, error) {
+	if options.Store == nil || options.Client == nil {
+		return nil, errors.New("token store and client are required")
+	}
+	if options.Interval == 0 {
+		options.Interval = MinRefreshValidationInterval
+	}
+	if options.Interval < MinRefreshValidationInterval {
+		return nil, fmt.Errorf("validation interval must be at least %s, got %s", MinRefreshValidationInterval, options.Interval)
+	}
+
+	return &RefreshScheduler{
+		store:     options.Store,
+		client:    options.Client,
+		secrets:   options.Secrets,
+		interval:  options.Interval,
+		clock:     clockOrDefault(options.Clock),
+		onRevoked: options.OnRevoked,
+		onError:   options.OnError,
+		onRunErr:  options.OnRunError,
+	}, nil
+}
+
+// Run calls RunOnce immediately and then every period until ctx is done, and returns ctx's error.
+// A run that fails to read the store is reported to OnRunError and retried on the next tick. period
+// must be positive.
+func (s *RefreshScheduler) Run(ctx context.Context, period time.Duration) error {
+	if period <= 0 {
+		return fmt.Errorf("run period must be positive, got %s", period)
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		if _, err := s.RunOnce(ctx); err != nil && ctx.Err() == nil && s.onRunErr != nil {
+			s.onRunErr(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce validates every stored token last validated at least Interval ago. Valid tokens get a new
+// ValidatedAt; tokens rejected with invalid_grant are deleted and passed to OnRevoked.
+func (s *RefreshScheduler) RunOnce(ctx context.Context) (RefreshRunResult, error) {
+	var result RefreshRunResult
+	now := s.clock.Now()
+
+	var due []StoredToken
+	err := s.store.Range(ctx, func(token StoredToken) bool {
+		if !token.ValidatedAt.IsZero() && now.Sub(token.ValidatedAt) < s.interval {
+			result.Skipped++
+		} else {
+			due = append(due, token)
+		}
+		return true
+	})
+	// Tokens that cannot be decrypted are reported one by one rather than failing the run for everyone
+	undecryptable, err := splitDecryptionErrors(err)
+	if err != nil {
+		return result, fmt.Errorf("listing refresh tokens: %w", err)
+	}
+	for _, decryptionErr := range undecryptable {
+		result.Failed++
+		if s.onError != nil {
+			s.onError(StoredToken{Subject: decryptionErr.Subject, ClientID: decryptionErr.ClientID}, decryptionErr)
+		}
+	}
+
+	for _, token := range due {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		revoked, err := s.validate(ctx, token)
+		switch {
+		case err != nil:
+			result.Failed++
+			if s.onError != nil {
+				s.onError(token, err)
+			}
+		case revoked:
+			result.Revoked++
+			if s.onRevoked != nil {
+				s.onRevoked(ctx, token)
+			}
+		default:
+			result.Validated++
+		}
+	}
+	return result, nil
+}
+
+// validate checks token with Apple and updates the store, reporting whether the token was revoked
+func (s *RefreshScheduler) validate(ctx context.Context, token StoredToken) (bool, error) {
+	req := ValidationRefreshRequest{ClientID: token.ClientID, RefreshToken: token.RefreshToken}
+	if s.secrets != nil {
+		secret, err := s.secrets.ClientSecret(ctx, token.ClientID)
+		if err != nil {
+			return false, err
+		}
+		req.ClientSecret = secret
+	}
+
+	var resp RefreshResponse
+	if err := s.client.VerifyRefreshToken(ctx, req, &resp); err != nil {
+		return false, err
+	}
+	if resp.Error != "" && resp.Error != "invalid_grant" {
+		return false, fmt.Errorf("apple rejected refresh token validation: %s: %s", resp.Error, resp.ErrorDescription)
+	}
+
+	// The user may have signed in again while Apple was answering; leave a newer token alone
+	current, err := s.store.Get(ctx, token.Subject, token.ClientID)
+	if errors.Is(err, ErrTokenNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current.RefreshToken != token.RefreshToken {
+		return false, nil
+	}
+
+	if resp.Error == "invalid_grant" {
+		return true, s.store.Delete(ctx, token.Subject, token.ClientID)
+	}
+	current.ValidatedAt = s.clock.Now()
+	return false, s.store.Put(ctx, *current)
+}
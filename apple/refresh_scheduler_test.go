@@ -0,0 +1,193 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshScheduler(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	store := NewEncryptedTokenStore(NewMemoryTokenStore(), newTestKEK(t))
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user1", ClientID: "cid", RefreshToken: "valid"}))
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user2", ClientID: "cid", RefreshToken: "revoked"}))
+
+	fake := NewFakeClient().
+		Respond("VerifyRefreshToken", RefreshResponse{AccessToken: "a"}, nil).
+		Respond("VerifyRefreshToken", RefreshResponse{Error: "invalid_grant"}, nil).
+		Respond("VerifyRefreshToken", RefreshResponse{AccessToken: "a"}, nil)
+
+	var revoked []string
+	scheduler, err := NewRefreshScheduler(RefreshSchedulerOptions{
+		Store:     store,
+		Client:    fake,
+		Clock:     clock,
+		OnRevoked: func(ctx context.Context, token StoredToken) { revoked = append(revoked, token.Subject) },
+	})
+	require.NoError(t, err)
+
+	result, err := scheduler.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RefreshRunResult{Validated: 1, Revoked: 1}, result)
+	assert.Equal(t, []string{"user2"}, revoked)
+	calls := fake.CallsTo("VerifyRefreshToken")
+	require.Len(t, calls, 2)
+	assert.Equal(t, ValidationRefreshRequest{ClientID: "cid", RefreshToken: "valid"}, calls[0].Request)
+
+	_, err = store.Get(ctx, "user2", "cid")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+	token, err := store.Get(ctx, "user1", "cid")
+	require.NoError(t, err)
+	assert.Equal(t, clock.Now(), token.ValidatedAt)
+
+	clock.Advance(23 * time.Hour)
+	result, err = scheduler.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RefreshRunResult{Skipped: 1}, result, "tokens are validated at most once a day")
+
+	clock.Advance(time.Hour)
+	result, err = scheduler.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RefreshRunResult{Validated: 1}, result)
+}
+
+func TestRefreshSchedulerErrors(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTokenStore()
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user1", ClientID: "cid", RefreshToken: "r"}))
+
+	boom := errors.New("boom")
+	fake := NewFakeClient().
+		Respond("VerifyRefreshToken", nil, boom).
+		Respond("VerifyRefreshToken", RefreshResponse{Error: "invalid_client"}, nil)
+
+	var failures []error
+	scheduler, err := NewRefreshScheduler(RefreshSchedulerOptions{
+		Store:   store,
+		Client:  fake,
+		OnError: func(token StoredToken, err error) { failures = append(failures, err) },
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		result, err := scheduler.RunOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, RefreshRunResult{Failed: 1}, result)
+	}
+	require.Len(t, failures, 2)
+	assert.ErrorIs(t, failures[0], boom)
+	assert.Contains(t, failures[1].Error(), "invalid_client")
+
+	token, err := store.Get(ctx, "user1", "cid")
+	require.NoError(t, err)
+	assert.True(t, token.ValidatedAt.IsZero(), "failed tokens stay due")
+}
+
+func TestRefreshSchedulerReportsUndecryptableTokens(t *testing.T) {
+	ctx := context.Background()
+	backing := NewMemoryTokenStore()
+	store := NewEncryptedTokenStore(backing, newTestKEK(t))
+	require.NoError(t, backing.Put(ctx, StoredToken{Subject: "user1", ClientID: "cid", RefreshToken: "v1.corrupt.record"}))
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user2", ClientID: "cid", RefreshToken: "valid"}))
+
+	fake := NewFakeClient().Respond("VerifyRefreshToken", RefreshResponse{AccessToken: "a"}, nil)
+	var failed []StoredToken
+	var failures []error
+	scheduler, err := NewRefreshScheduler(RefreshSchedulerOptions{
+		Store:  store,
+		Client: fake,
+		OnError: func(token StoredToken, err error) {
+			failed = append(failed, token)
+			failures = append(failures, err)
+		},
+	})
+	require.NoError(t, err)
+
+	result, err := scheduler.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, RefreshRunResult{Validated: 1, Failed: 1}, result)
+	assert.Equal(t, []StoredToken{{Subject: "user1", ClientID: "cid"}}, failed)
+	var decryptionErr *TokenDecryptionError
+	assert.ErrorAs(t, failures[0], &decryptionErr)
+	calls := fake.CallsTo("VerifyRefreshToken")
+	require.Len(t, calls, 1)
+	assert.Equal(t, ValidationRefreshRequest{ClientID: "cid", RefreshToken: "valid"}, calls[0].Request)
+}
+
+func TestRefreshSchedulerKeepsReplacedToken(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTokenStore()
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user1", ClientID: "cid", RefreshToken: "old"}))
+
+	fake := NewFakeClient().Respond("VerifyRefreshToken", RefreshResponse{Error: "invalid_grant"}, nil)
+	scheduler, err := NewRefreshScheduler(RefreshSchedulerOptions{Store: store, Client: &signInDuringValidation{FakeClient: fake, store: store}})
+	require.NoError(t, err)
+
+	_, err = scheduler.RunOnce(ctx)
+	require.NoError(t, err)
+	token, err := store.Get(ctx, "user1", "cid")
+	require.NoError(t, err)
+	assert.Equal(t, "new", token.RefreshToken)
+}
+
+// signInDuringValidation stores a new refresh token for the user while their old one is being validated
+type signInDuringValidation struct {
+	*FakeClient
+	store TokenStore
+}
+
+func (s *signInDuringValidation) VerifyRefreshToken(ctx context.Context, req ValidationRefreshRequest, result interface{}) error {
+	if err := s.store.Put(ctx, StoredToken{Subject: "user1", ClientID: req.ClientID, RefreshToken: "new"}); err != nil {
+		return err
+	}
+	return s.FakeClient.VerifyRefreshToken(ctx, req, result)
+}
+
+func TestNewRefreshSchedulerValidation(t *testing.T) {
+	_, err := NewRefreshScheduler(RefreshSchedulerOptions{Client: NewFakeClient()})
+	assert.Error(t, err)
+
+	_, err = NewRefreshScheduler(RefreshSchedulerOptions{Store: NewMemoryTokenStore(), Client: NewFakeClient(), Interval: time.Hour})
+	assert.Error(t, err)
+}
+
+// brokenRangeStore is a TokenStore whose Range always fails
+type brokenRangeStore struct {
+	*MemoryTokenStore
+	err error
+}
+
+func (s brokenRangeStore) Range(ctx context.Context, fn func(StoredToken) bool) error {
+	return s.err
+}
+
+func TestRefreshSchedulerRun(t *testing.T) {
+	boom := errors.New("database gone")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var runErrs []error
+	scheduler, err := NewRefreshScheduler(RefreshSchedulerOptions{
+		Store:  brokenRangeStore{MemoryTokenStore: NewMemoryTokenStore(), err: boom},
+		Client: NewFakeClient(),
+		OnRunError: func(err error) {
+			runErrs = append(runErrs, err)
+			if len(runErrs) == 2 {
+				cancel()
+			}
+		},
+	})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, scheduler.Run(ctx, time.Millisecond), context.Canceled)
+	require.Len(t, runErrs, 2, "a failed run is reported and retried on the next tick")
+	assert.ErrorIs(t, runErrs[0], boom)
+
+	assert.Error(t, scheduler.Run(context.Background(), 0), "a non-positive period is rejected rather than panicking")
+	assert.Error(t, scheduler.Run(context.Background(), -time.Second))
+}
@@ -0,0 +1,72 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefresh(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+	now := time.Now().Truncate(time.Second)
+	clock := NewFakeClock(now)
+
+	withIDToken := true
+	c := newExchangeTestClientWithJWKS(t, clock, jwksHandler, func(r *http.Request) (int, interface{}) {
+		assert.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		resp := RefreshResponse{AccessToken: "access", TokenType: "bearer", ExpiresIn: 3600}
+		if withIDToken {
+			claims := exchangeTestClaims(now, r.PostForm.Get("client_id"), "")
+			claims["email"] = "relay@privaterelay.appleid.com"
+			resp.IDToken = makeIDToken(t, privKey, claims)
+		}
+		return http.StatusOK, resp
+	})
+
+	req := RefreshRequest{ClientID: "com.example.app", ClientSecret: "secret", RefreshToken: "refresh", Subject: "user123"}
+	session, err := c.Refresh(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "refresh", session.RefreshToken)
+	assert.Equal(t, now.Add(time.Hour), session.ExpiresAt)
+	require.NotNil(t, session.Claims)
+	assert.Equal(t, "relay@privaterelay.appleid.com", session.Claims.Email)
+
+	req.Subject = "someone-else"
+	_, err = c.Refresh(context.Background(), req)
+	assert.ErrorIs(t, err, ErrSubjectMismatch)
+
+	withIDToken = false
+	req.Subject = "user123"
+	_, err = c.Refresh(context.Background(), req)
+	assert.ErrorIs(t, err, ErrMissingIDToken, "the subject cannot be checked without an id_token")
+}
+
+func TestRefreshErrors(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+	status := http.StatusOK
+	c := newExchangeTestClientWithJWKS(t, nil, jwksHandler, func(r *http.Request) (int, interface{}) {
+		if status != http.StatusOK {
+			return status, map[string]string{"error": "invalid_grant"}
+		}
+		return status, RefreshResponse{IDToken: makeIDToken(t, privKey, exchangeTestClaims(time.Now(), "com.example.other", ""))}
+	})
+
+	req := RefreshRequest{ClientID: "com.example.app", ClientSecret: "secret", RefreshToken: "refresh"}
+	_, err := c.Refresh(context.Background(), req)
+	assert.ErrorContains(t, err, "subject are required")
+
+	req.Subject = "user123"
+	_, err = c.Refresh(context.Background(), req)
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+
+	status = http.StatusBadRequest
+	_, err = c.Refresh(context.Background(), req)
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_grant", apiErr.Code)
+}
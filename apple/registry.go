@@ -0,0 +1,207 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrUnknownTenant is returned when a token's audience does not belong to any registered tenant
+var ErrUnknownTenant = errors.New("no tenant registered for client ID")
+
+// Tenant is one team's Sign in with Apple configuration within a Registry
+type Tenant struct {
+	// Name identifies the tenant, e.g. the white-label app it belongs to
+	Name string
+
+	// TeamID is the tenant's 10-character Team ID. When Secrets is a RotatingSecretProvider, Register
+	// checks that it signs for this team.
+	TeamID string
+
+	// ClientIDs are the Services IDs and bundle IDs that belong to the tenant.
+	// Tokens are routed to the tenant when their aud matches one of these.
+	ClientIDs []string
+
+	// Secrets signs client secrets for the tenant's client IDs, typically a RotatingSecretProvider.
+	// It may be nil for tenants that only verify tokens.
+	Secrets SecretProvider
+}
+
+// Registry serves many tenants from a single Client, so that they share one JWKS cache and HTTP client.
+// Tenants are looked up by client ID, which is the aud claim of the tokens Apple issues for them.
+// It is safe for concurrent use and tenants may be registered while it is serving requests.
+type Registry struct {
+	client *Client
+
+	mu         sync.RWMutex
+	tenants    map[string]*Tenant
+	byClientID map[string]*Tenant
+}
+
+// NewRegistry creates an empty Registry with its own Client built from options. The registry is the
+// client's secret provider, so requests with an empty ClientSecret are signed by the tenant owning
+// their client ID; options.Secrets must be left nil.
+func NewRegistry(options ClientOptions) (*Registry, error) {
+	if options.Secrets != nil {
+		return nil, errors.New("client secrets come from the tenants; leave ClientOptions.Secrets nil")
+	}
+	r := &Registry{
+		tenants:    make(map[string]*Tenant),
+		byClientID: make(map[string]*Tenant),
+	}
+	options.Secrets = r
+	r.client = NewWithOptions(options)
+	return r, nil
+}
+
+// Client returns the shared Client used for all tenants. Reload it through Registry.Reload, which keeps
+// the registry as its secret provider.
+func (r *Registry) Client() *Client {
+	return r.client
+}
+
+// Reload replaces the configuration of the shared Client as Client.Reload does, keeping the registry
+// as its secret provider. options.Secrets must be left nil.
+func (r *Registry) Reload(options ClientOptions) error {
+	if options.Secrets != nil {
+		return errors.New("client secrets come from the tenants; leave ClientOptions.Secrets nil")
+	}
+	options.Secrets = r
+	r.client.Reload(options)
+	return nil
+}
+
+// Register adds a tenant. Names and client IDs must be unique across the registry, and TeamID must match
+// the team of a RotatingSecretProvider.
+func (r *Registry) Register(tenant Tenant) error {
+	if tenant.Name == "" {
+		return errors.New("tenant name is required")
+	}
+	if len(tenant.ClientIDs) == 0 {
+		return fmt.Errorf("tenant %q must have at least one client ID", tenant.Name)
+	}
+	if provider, ok := tenant.Secrets.(*RotatingSecretProvider); ok && tenant.TeamID != "" && provider.teamID != tenant.TeamID {
+		return fmt.Errorf("tenant %q has team ID %s but its secret provider signs for %s", tenant.Name, tenant.TeamID, provider.teamID)
+	}
+
+	t := tenant
+	t.ClientIDs = append([]string(nil), tenant.ClientIDs...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.tenants[t.Name]; exists {
+		return fmt.Errorf("tenant %q is already registered", t.Name)
+	}
+	for _, clientID := range t.ClientIDs {
+		if clientID == "" {
+			return fmt.Errorf("tenant %q has an empty client ID", t.Name)
+		}
+		if other, exists := r.byClientID[clientID]; exists {
+			return fmt.Errorf("client ID %q of tenant %q is already registered to tenant %q", clientID, t.Name, other.Name)
+		}
+	}
+
+	r.tenants[t.Name] = &t
+	for _, clientID := range t.ClientIDs {
+		r.byClientID[clientID] = &t
+	}
+	return nil
+}
+
+// Unregister removes the tenant with the given name and reports whether it was registered.
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tenants[name]
+	if !ok {
+		return false
+	}
+	delete(r.tenants, name)
+	for _, clientID := range t.ClientIDs {
+		delete(r.byClientID, clientID)
+	}
+	return true
+}
+
+// Tenant returns a copy of the tenant that owns clientID. Changing it does not affect the registry;
+// Unregister and Register the tenant again instead.
+func (r *Registry) Tenant(clientID string) (*Tenant, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byClientID[clientID]
+	if !ok {
+		return nil, false
+	}
+	tenant := *t
+	tenant.ClientIDs = append([]string(nil), t.ClientIDs...)
+	return &tenant, true
+}
+
+// ClientSecret returns a client secret for clientID from its tenant's SecretProvider,
+// so a Registry can itself be used wherever a SecretProvider is expected.
+func (r *Registry) ClientSecret(ctx context.Context, clientID string) (string, error) {
+	t, ok := r.Tenant(clientID)
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownTenant, clientID)
+	}
+	if t.Secrets == nil {
+		return "", fmt.Errorf("tenant %q has no secret provider", t.Name)
+	}
+	return t.Secrets.ClientSecret(ctx, clientID)
+}
+
+// SecretRejected passes Apple's rejection of a secret on to the tenant's SecretProvider when it
+// implements SecretFailover, so each tenant's RotatingSecretProvider fails over on its own.
+func (r *Registry) SecretRejected(ctx context.Context, clientID, clientSecret string) bool {
+	t, ok := r.Tenant(clientID)
+	if !ok {
+		return false
+	}
+	failover, ok := t.Secrets.(SecretFailover)
+	return ok && failover.SecretRejected(ctx, clientID, clientSecret)
+}
+
+// VerifyIDToken routes the id_token to the tenant named by its aud claim and verifies it with
+// Client.VerifyIDToken against that client ID. Tokens for unregistered audiences fail with ErrUnknownTenant.
+func (r *Registry) VerifyIDToken(ctx context.Context, idToken string) (*IDTokenClaims, *Tenant, error) {
+	token, _, err := new(jwt.Parser).ParseUnverified(idToken, jwt.MapClaims{})
+	if err != nil {
+		return nil, nil, err
+	}
+	audiences, err := token.Claims.GetAudience()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, aud := range audiences {
+		t, ok := r.Tenant(aud)
+		if !ok {
+			continue
+		}
+		claims, err := r.client.VerifyIDToken(ctx, idToken, aud)
+		if err != nil {
+			return nil, nil, err
+		}
+		return claims, t, nil
+	}
+	return nil, nil, fmt.Errorf("%w %v", ErrUnknownTenant, []string(audiences))
+}
+
+// ParseServerNotification verifies a server-to-server notification with the shared Client and returns
+// the tenant named by its aud claim. Notifications for unregistered audiences fail with ErrUnknownTenant.
+func (r *Registry) ParseServerNotification(ctx context.Context, jwtPayload string) (*ServerNotificationClaims, *Tenant, error) {
+	claims, err := r.client.ParseServerNotification(ctx, jwtPayload)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, ok := r.Tenant(claims.Audience)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q", ErrUnknownTenant, claims.Audience)
+	}
+	return claims, t, nil
+}
@@ -0,0 +1,184 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryRegister(t *testing.T) {
+	r, err := NewRegistry(ClientOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, r.Client())
+
+	require.NoError(t, r.Register(Tenant{Name: "acme", TeamID: "ACME000001", ClientIDs: []string{"com.acme.app", "com.acme.web"}}))
+
+	other, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{TeamID: "OTHER00001", Keys: newTestSigningKeys(t, "OTHERKEY01")})
+	require.NoError(t, err)
+	assert.Error(t, r.Register(Tenant{Name: "mismatch", TeamID: "MISM000001", ClientIDs: []string{"com.mismatch.app"}, Secrets: other}), "the provider must sign for the tenant's team")
+
+	assert.Error(t, r.Register(Tenant{ClientIDs: []string{"com.other.app"}}), "name is required")
+	assert.Error(t, r.Register(Tenant{Name: "empty"}), "client IDs are required")
+	assert.Error(t, r.Register(Tenant{Name: "acme", ClientIDs: []string{"com.other.app"}}), "names must be unique")
+	assert.Error(t, r.Register(Tenant{Name: "copycat", ClientIDs: []string{"com.acme.web"}}), "client IDs must be unique")
+
+	tenant, ok := r.Tenant("com.acme.web")
+	require.True(t, ok)
+	assert.Equal(t, "acme", tenant.Name)
+
+	_, ok = r.Tenant("com.copycat.app")
+	assert.False(t, ok, "a failed registration must not leave partial state")
+
+	tenant.ClientIDs[0] = "com.changed.app"
+	tenant, ok = r.Tenant("com.acme.web")
+	require.True(t, ok)
+	assert.Equal(t, []string{"com.acme.app", "com.acme.web"}, tenant.ClientIDs, "callers get a copy")
+
+	assert.True(t, r.Unregister("acme"))
+	assert.False(t, r.Unregister("acme"))
+	_, ok = r.Tenant("com.acme.app")
+	assert.False(t, ok)
+}
+
+func TestRegistryClientSecret(t *testing.T) {
+	provider, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{
+		TeamID: "ACME000001",
+		Keys:   newTestSigningKeys(t, "ACMEKEY001"),
+	})
+	require.NoError(t, err)
+
+	r, err := NewRegistry(ClientOptions{})
+	require.NoError(t, err)
+	require.NoError(t, r.Register(Tenant{Name: "acme", TeamID: "ACME000001", ClientIDs: []string{"com.acme.app"}, Secrets: provider}))
+	require.NoError(t, r.Register(Tenant{Name: "verify-only", ClientIDs: []string{"com.verify.app"}}))
+
+	var _ SecretProvider = r
+
+	secret, err := r.ClientSecret(context.Background(), "com.acme.app")
+	require.NoError(t, err)
+	info, err := InspectClientSecret(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "ACME000001", info.TeamID)
+	assert.Equal(t, "com.acme.app", info.ClientID)
+
+	_, err = r.ClientSecret(context.Background(), "com.unknown.app")
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+
+	_, err = r.ClientSecret(context.Background(), "com.verify.app")
+	assert.Error(t, err)
+}
+
+func TestRegistrySignsClientRequests(t *testing.T) {
+	var secrets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		secret := r.PostForm.Get("client_secret")
+		secrets = append(secrets, secret)
+		if secretKeyID(t, secret) == "REVOKED001" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"a"}`))
+	}))
+	defer srv.Close()
+
+	provider, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{
+		TeamID: "ACME000001",
+		Keys:   newTestSigningKeys(t, "REVOKED001", "ACMEKEY001"),
+	})
+	require.NoError(t, err)
+
+	r, err := NewRegistry(ClientOptions{ValidationURL: "http://unused.invalid"})
+	require.NoError(t, err)
+	require.NoError(t, r.Reload(ClientOptions{ValidationURL: srv.URL}))
+	require.NoError(t, r.Register(Tenant{Name: "acme", TeamID: "ACME000001", ClientIDs: []string{"com.acme.app"}, Secrets: provider}))
+
+	var resp ValidationResponse
+	require.NoError(t, r.Client().VerifyAppToken(context.Background(), AppValidationTokenRequest{ClientID: "com.acme.app", Code: "code"}, &resp))
+	assert.Equal(t, "a", resp.AccessToken)
+	require.Len(t, secrets, 2)
+	info, err := InspectClientSecret(secrets[1])
+	require.NoError(t, err)
+	assert.Equal(t, "ACME000001", info.TeamID, "the tenant's provider signs the request")
+	assert.Equal(t, "ACMEKEY001", info.KeyID, "the tenant's provider fails over through the registry")
+
+	assert.Same(t, r, r.Client().Options().Secrets, "the registry stays the secret provider across reloads")
+
+	own := &staticSecretProvider{secret: "own"}
+	_, err = NewRegistry(ClientOptions{Secrets: own})
+	assert.Error(t, err, "secrets come from the tenants")
+	assert.Error(t, r.Reload(ClientOptions{Secrets: own}))
+}
+
+type staticSecretProvider struct{ secret string }
+
+func (p *staticSecretProvider) ClientSecret(ctx context.Context, clientID string) (string, error) {
+	return p.secret, nil
+}
+
+func TestRegistryRoutesTokens(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+	var jwksCalls atomic.Int32
+	jwksSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwksCalls.Add(1)
+		jwksHandler(w, r)
+	}))
+	defer jwksSrv.Close()
+
+	r, err := NewRegistry(ClientOptions{AppleKeysURL: jwksSrv.URL})
+	require.NoError(t, err)
+	require.NoError(t, r.Register(Tenant{Name: "acme", ClientIDs: []string{"com.acme.app"}}))
+	require.NoError(t, r.Register(Tenant{Name: "globex", ClientIDs: []string{"com.globex.app"}}))
+
+	idToken := func(aud interface{}) string {
+		return makeIDToken(t, privKey, jwt.MapClaims{
+			"iss": AppleIssuer,
+			"aud": aud,
+			"sub": "user123",
+			"iat": float64(time.Now().Unix()),
+			"exp": float64(time.Now().Add(time.Hour).Unix()),
+		})
+	}
+
+	claims, tenant, err := r.VerifyIDToken(context.Background(), idToken("com.acme.app"))
+	require.NoError(t, err)
+	assert.Equal(t, "acme", tenant.Name)
+	assert.Equal(t, "user123", claims.Subject)
+
+	_, tenant, err = r.VerifyIDToken(context.Background(), idToken([]string{"com.unknown.app", "com.globex.app"}))
+	require.NoError(t, err)
+	assert.Equal(t, "globex", tenant.Name)
+
+	assert.Equal(t, int32(1), jwksCalls.Load(), "tenants share one JWKS cache")
+
+	_, _, err = r.VerifyIDToken(context.Background(), idToken("com.unknown.app"))
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+
+	_, _, err = r.VerifyIDToken(context.Background(), "not.a.token")
+	assert.Error(t, err)
+
+	notification := func(aud string) string {
+		return makeNotificationToken(t, privKey, testKID, jwt.MapClaims{
+			"iss": AppleIssuer,
+			"aud": aud,
+			"iat": float64(time.Now().Unix()),
+			"exp": float64(time.Now().Add(time.Hour).Unix()),
+		}, map[string]interface{}{"type": "account-delete", "sub": "user123", "event_time": time.Now().Unix()})
+	}
+
+	n, tenant, err := r.ParseServerNotification(context.Background(), notification("com.globex.app"))
+	require.NoError(t, err)
+	assert.Equal(t, "globex", tenant.Name)
+	assert.Equal(t, "account-delete", n.Events.Type)
+
+	_, _, err = r.ParseServerNotification(context.Background(), notification("com.unknown.app"))
+	assert.ErrorIs(t, err, ErrUnknownTenant)
+}
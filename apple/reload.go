@@ -0,0 +1,49 @@
+package apple
+
+import (
+	"crypto"
+	"time"
+)
+
+// Options returns the configuration currently in effect, with defaults applied.
+func (c *Client) Options() ClientOptions {
+	return c.config.Load().options
+}
+
+// Reload atomically replaces the client's configuration: endpoints, issuer, HTTP client, JWKS cache TTL,
+// clock, secret provider and exchange cache. Defaults are applied exactly as in NewWithOptions.
+//
+// Calls already in flight finish with the configuration they started with; calls made after Reload
+// returns use the new one. Cached Apple keys are discarded when the JWKS endpoint changes and are
+// otherwise kept, with the new TTL applied to them. Hooks registered with OnReload run after the swap.
+func (c *Client) Reload(options ClientOptions) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	next := newClientConfig(options)
+	previous := c.config.Swap(next)
+
+	if previous.keysURL != next.keysURL {
+		c.jwksMu.Lock()
+		c.jwksCache = make(map[string]crypto.PublicKey)
+		c.jwksSet = nil
+		c.jwksFetchedAt = time.Time{}
+		c.jwksMu.Unlock()
+
+		c.jwksRefreshMu.Lock()
+		c.jwksRefreshErr = nil
+		c.jwksRefreshMu.Unlock()
+	}
+
+	for _, hook := range c.reloadHooks {
+		hook(previous.options, next.options)
+	}
+}
+
+// OnReload registers fn to be called after every Reload with the previous and the new configuration.
+// Hooks run synchronously, in registration order, on the goroutine that called Reload.
+func (c *Client) OnReload(fn func(previous, current ClientOptions)) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+	c.reloadHooks = append(c.reloadHooks, fn)
+}
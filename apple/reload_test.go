@@ -0,0 +1,168 @@
+package apple
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadInFlightCallsKeepOldConfig(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	oldSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte(`{"access_token":"old"}`))
+	}))
+	defer oldSrv.Close()
+	newSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"new"}`))
+	}))
+	defer newSrv.Close()
+
+	c := NewWithOptions(ClientOptions{ValidationURL: oldSrv.URL})
+
+	var wg sync.WaitGroup
+	var inFlight ValidationResponse
+	var inFlightErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		inFlightErr = c.VerifyRefreshToken(context.Background(), ValidationRefreshRequest{ClientID: "cid", ClientSecret: "secret"}, &inFlight)
+	}()
+
+	<-started
+	c.Reload(ClientOptions{ValidationURL: newSrv.URL})
+	close(release)
+	wg.Wait()
+
+	require.NoError(t, inFlightErr)
+	assert.Equal(t, "old", inFlight.AccessToken, "in-flight calls should finish on the old configuration")
+
+	var after ValidationResponse
+	require.NoError(t, c.VerifyRefreshToken(context.Background(), ValidationRefreshRequest{ClientID: "cid", ClientSecret: "secret"}, &after))
+	assert.Equal(t, "new", after.AccessToken, "new calls should use the reloaded configuration")
+}
+
+func TestReloadSecrets(t *testing.T) {
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		received = r.PostForm.Get("client_secret")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	c := NewWithOptions(ClientOptions{ValidationURL: srv.URL})
+
+	provider, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{
+		TeamID: "TEAM000001",
+		Keys:   newTestSigningKeys(t, "KEY0000002"),
+	})
+	require.NoError(t, err)
+	c.Reload(ClientOptions{ValidationURL: srv.URL, Secrets: provider})
+
+	var resp ValidationResponse
+	require.NoError(t, c.VerifyRefreshToken(context.Background(), ValidationRefreshRequest{ClientID: "cid"}, &resp))
+	assert.Equal(t, "KEY0000002", secretKeyID(t, received), "missing secrets should come from the reloaded provider")
+
+	require.NoError(t, c.VerifyRefreshToken(context.Background(), ValidationRefreshRequest{ClientID: "cid", ClientSecret: "explicit"}, &resp))
+	assert.Equal(t, "explicit", received, "an explicit secret takes precedence over the provider")
+}
+
+func TestReloadHooks(t *testing.T) {
+	c := NewWithOptions(ClientOptions{JWKSCacheTTL: time.Minute})
+
+	var calls []string
+	c.OnReload(func(previous, current ClientOptions) {
+		assert.Equal(t, time.Minute, previous.JWKSCacheTTL)
+		assert.Equal(t, time.Hour, current.JWKSCacheTTL)
+		assert.Equal(t, ValidationURL, current.ValidationURL, "hooks receive options with defaults applied")
+		calls = append(calls, "first")
+	})
+	c.OnReload(func(previous, current ClientOptions) {
+		calls = append(calls, "second")
+	})
+
+	c.Reload(ClientOptions{JWKSCacheTTL: time.Hour})
+	assert.Equal(t, []string{"first", "second"}, calls)
+	assert.Equal(t, time.Hour, c.Options().JWKSCacheTTL)
+}
+
+func TestReloadResetsJWKSCache(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		jwksHandler(w, r)
+	}))
+	defer srv.Close()
+
+	c := NewWithOptions(ClientOptions{AppleKeysURL: srv.URL})
+	token := makeIDToken(t, privKey, jwt.MapClaims{
+		"iss": AppleIssuer,
+		"aud": "com.example.app",
+		"sub": "user123",
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+
+	_, err := c.VerifyIDToken(context.Background(), token, "com.example.app")
+	require.NoError(t, err)
+	require.Equal(t, 1, fetches)
+
+	c.Reload(ClientOptions{AppleKeysURL: srv.URL, JWKSCacheTTL: time.Hour})
+	_, err = c.VerifyIDToken(context.Background(), token, "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, 1, fetches, "the key cache survives a reload that keeps the JWKS endpoint")
+
+	c.Reload(ClientOptions{AppleKeysURL: srv.URL + "/"})
+	c.jwksMu.RLock()
+	assert.Empty(t, c.jwksCache, "changing the JWKS endpoint discards cached keys")
+	c.jwksMu.RUnlock()
+	_, err = c.VerifyIDToken(context.Background(), token, "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+}
+
+func TestReloadDuringJWKSFetch(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	oldSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		jwksHandler(w, r)
+	}))
+	defer oldSrv.Close()
+
+	c := NewWithOptions(ClientOptions{AppleKeysURL: oldSrv.URL})
+	token := makeIDToken(t, privKey, jwt.MapClaims{
+		"iss": AppleIssuer,
+		"aud": "com.example.app",
+		"sub": "user123",
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+
+	done := make(chan error)
+	go func() {
+		_, err := c.VerifyIDToken(context.Background(), token, "com.example.app")
+		done <- err
+	}()
+
+	<-started
+	c.Reload(ClientOptions{AppleKeysURL: oldSrv.URL + "/moved"})
+	close(release)
+
+	require.NoError(t, <-done, "the verification in flight finishes on the configuration it started with")
+	c.jwksMu.RLock()
+	assert.Empty(t, c.jwksCache, "keys from the previous endpoint are not cached")
+	assert.Nil(t, c.jwksSet)
+	c.jwksMu.RUnlock()
+}
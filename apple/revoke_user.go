@@ -0,0 +1,118 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// RevokeUserRequest holds the tokens kept for one user of a client ID. Either token may be empty.
+type RevokeUserRequest struct {
+	// ClientID is the client ID the tokens were issued to
+	ClientID string
+
+	// ClientSecret is the client secret. It may be left empty when ClientOptions.Secrets is set.
+	ClientSecret string
+
+	// RefreshToken is the user's refresh token
+	RefreshToken string
+
+	// AccessToken is the user's access token
+	AccessToken string
+}
+
+// TokenRevocation is the outcome of revoking one token
+type TokenRevocation struct {
+	// TokenType is "refresh_token" or "access_token"
+	TokenType string
+
+	// AlreadyInvalid is set when Apple answered invalid_grant: the token was revoked or expired before.
+	// The token counts as revoked.
+	AlreadyInvalid bool
+
+	// Err is set when the token could not be revoked. Retry the revocation later.
+	Err error
+}
+
+// Revoked reports whether the token is no longer usable
+func (r *TokenRevocation) Revoked() bool {
+	return r.Err == nil
+}
+
+// RevokeUserResult describes what RevokeUser revoked. Tokens that were not given are nil.
+type RevokeUserResult struct {
+	RefreshToken *TokenRevocation
+	AccessToken  *TokenRevocation
+}
+
+// Complete reports whether every given token is revoked
+func (r *RevokeUserResult) Complete() bool {
+	return r.Err() == nil
+}
+
+// Err joins the errors of the tokens that must be retried, or returns nil
+func (r *RevokeUserResult) Err() error {
+	var errs []error
+	for _, revocation := range []*TokenRevocation{r.RefreshToken, r.AccessToken} {
+		if revocation != nil && revocation.Err != nil {
+			errs = append(errs, fmt.Errorf("revoking %s: %w", revocation.TokenType, revocation.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RevokeUser revokes the tokens held for a user, as required when an app lets the user delete their account
+// (App Store Review Guideline 5.1.1(v)). It is RevokeUser with c as the revoker.
+func (c *Client) RevokeUser(ctx context.Context, req RevokeUserRequest) *RevokeUserResult {
+	return RevokeUser(ctx, c, req)
+}
+
+// RevokeUser revokes the refresh token and then the access token of req through revoker. Revoking the
+// refresh token also invalidates the access tokens issued from it, so it goes first; the access token is
+// still revoked in case it came from another grant. Tokens Apple rejects with invalid_grant are already
+// unusable and count as revoked. A failure on one token does not stop the other from being revoked.
+func RevokeUser(ctx context.Context, revoker TokenRevoker, req RevokeUserRequest) *RevokeUserResult {
+	result := &RevokeUserResult{}
+	if req.RefreshToken != "" {
+		result.RefreshToken = revokeToken(ctx, "refresh_token", func(resp *RevokeResponse) error {
+			return revoker.RevokeRefreshToken(ctx, RevokeRefreshTokenRequest{
+				ClientID:     req.ClientID,
+				ClientSecret: req.ClientSecret,
+				RefreshToken: req.RefreshToken,
+			}, resp)
+		})
+	}
+	if req.AccessToken != "" {
+		result.AccessToken = revokeToken(ctx, "access_token", func(resp *RevokeResponse) error {
+			return revoker.RevokeAccessToken(ctx, RevokeAccessTokenRequest{
+				ClientID:     req.ClientID,
+				ClientSecret: req.ClientSecret,
+				AccessToken:  req.AccessToken,
+			}, resp)
+		})
+	}
+	return result
+}
+
+// revokeToken calls revoke and interprets Apple's answer
+func revokeToken(ctx context.Context, tokenType string, revoke func(resp *RevokeResponse) error) *TokenRevocation {
+	revocation := &TokenRevocation{TokenType: tokenType}
+	if err := ctx.Err(); err != nil {
+		revocation.Err = err
+		return revocation
+	}
+
+	var resp RevokeResponse
+	if err := revoke(&resp); err != nil {
+		revocation.Err = err
+		return revocation
+	}
+	switch resp.Error {
+	case "":
+	case "invalid_grant":
+		revocation.AlreadyInvalid = true
+	default:
+		revocation.Err = fmt.Errorf("apple returned %s: %s", resp.Error, resp.ErrorDescription)
+	}
+	return revocation
+}
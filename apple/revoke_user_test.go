@@ -0,0 +1,62 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevokeUser(t *testing.T) {
+	fake := NewFakeClient().
+		Respond("RevokeRefreshToken", nil, nil).
+		Respond("RevokeAccessToken", `{"error":"invalid_grant"}`, nil)
+
+	result := RevokeUser(context.Background(), fake, RevokeUserRequest{ClientID: "cid", ClientSecret: "s", RefreshToken: "r", AccessToken: "a"})
+	assert.True(t, result.Complete())
+	assert.NoError(t, result.Err())
+	require.NotNil(t, result.RefreshToken)
+	assert.True(t, result.RefreshToken.Revoked())
+	assert.False(t, result.RefreshToken.AlreadyInvalid)
+	require.NotNil(t, result.AccessToken)
+	assert.True(t, result.AccessToken.Revoked())
+	assert.True(t, result.AccessToken.AlreadyInvalid)
+
+	calls := fake.Calls()
+	require.Len(t, calls, 2)
+	assert.Equal(t, "RevokeRefreshToken", calls[0].Method, "the refresh token is revoked first")
+	assert.Equal(t, RevokeAccessTokenRequest{ClientID: "cid", ClientSecret: "s", AccessToken: "a"}, calls[1].Request)
+}
+
+func TestRevokeUserPartialFailure(t *testing.T) {
+	boom := errors.New("connection reset")
+	fake := NewFakeClient().
+		Respond("RevokeRefreshToken", nil, boom).
+		Respond("RevokeAccessToken", `{"error":"invalid_client","error_description":"bad secret"}`, nil)
+
+	result := RevokeUser(context.Background(), fake, RevokeUserRequest{ClientID: "cid", RefreshToken: "r", AccessToken: "a"})
+	assert.False(t, result.Complete())
+	assert.ErrorIs(t, result.RefreshToken.Err, boom)
+	assert.Len(t, fake.CallsTo("RevokeAccessToken"), 1, "the access token is still revoked after the refresh token failed")
+	assert.Contains(t, result.Err().Error(), "revoking access_token: apple returned invalid_client: bad secret")
+	assert.ErrorIs(t, result.Err(), boom)
+}
+
+func TestRevokeUserOnlyGivenTokens(t *testing.T) {
+	fake := NewFakeClient().Respond("RevokeAccessToken", nil, nil)
+
+	result := RevokeUser(context.Background(), fake, RevokeUserRequest{ClientID: "cid", AccessToken: "a"})
+	assert.True(t, result.Complete())
+	assert.Nil(t, result.RefreshToken)
+	assert.Empty(t, fake.CallsTo("RevokeRefreshToken"))
+}
+
+func TestClientRevokeUser(t *testing.T) {
+	c := newTypedTestServer(t, 400, `{"error":"invalid_grant"}`)
+
+	result := c.RevokeUser(context.Background(), RevokeUserRequest{ClientID: "cid", ClientSecret: "s", RefreshToken: "r"})
+	assert.True(t, result.Complete())
+	assert.True(t, result.RefreshToken.AlreadyInvalid)
+}
@@ -1,14 +1,54 @@
 package apple
 
 import (
-	"crypto/x509"
-	"encoding/pem"
-	"errors"
+	"fmt"
 	"time"
+)
 
-	"github.com/golang-jwt/jwt/v5"
+const (
+	// DefaultClientSecretLifetime is the lifetime given to client secrets when none is specified: 180 days minus one second.
+	DefaultClientSecretLifetime = time.Hour*24*180 - time.Second
+	// MaxClientSecretLifetime is the longest lifetime Apple accepts for a client secret (15777000 seconds, about 6 months).
+	MaxClientSecretLifetime = 15777000 * time.Second
 )
 
+// ClientSecretOptions configures GenerateClientSecretWithOptions
+type ClientSecretOptions struct {
+	// TeamID is your 10-character Team ID. It becomes the iss claim.
+	TeamID string
+
+	// ClientID is your Services ID or bundle ID. It becomes the sub claim.
+	ClientID string
+
+	// KeyID is the 10-character Key ID of the signing key. It becomes the kid header.
+	KeyID string
+
+	// Lifetime is how long the secret is valid for. Defaults to DefaultClientSecretLifetime
+	// and may not exceed MaxClientSecretLifetime.
+	Lifetime time.Duration
+
+	// IssuedAt overrides the iat claim. Defaults to the current time of Clock.
+	IssuedAt time.Time
+
+	// Audience overrides the aud claim, e.g. for a sandbox or mock server. Defaults to https://appleid.apple.com.
+	Audience string
+
+	// Clock supplies the current time. Defaults to SystemClock.
+	Clock Clock
+}
+
+// ClientSecret is a signed client secret together with its validity window
+type ClientSecret struct {
+	// Token is the signed JWT to send as client_secret
+	Token string
+
+	// IssuedAt is the iat claim of the token
+	IssuedAt time.Time
+
+	// ExpiresAt is the exp claim of the token. Generate a new secret before this time.
+	ExpiresAt time.Time
+}
+
 /*
 GenerateClientSecret generates the client secret used to make requests to the validation server.
 The secret expires after 6 months
@@ -19,35 +59,70 @@ clientID - Your Services ID, e.g. com.aaronparecki.services
 keyID - Find the 10-char Key ID value from the portal
 */
 func GenerateClientSecret(signingKey, teamID, clientID, keyID string) (string, error) {
-	block, _ := pem.Decode([]byte(signingKey))
-	if block == nil {
-		return "", errors.New("empty block after decoding")
-	}
+	return GenerateClientSecretWithClock(signingKey, teamID, clientID, keyID, SystemClock)
+}
 
-	privKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+// GenerateClientSecretWithClock is GenerateClientSecret with the issued-at time taken from clock.
+// A nil clock uses SystemClock.
+func GenerateClientSecretWithClock(signingKey, teamID, clientID, keyID string, clock Clock) (string, error) {
+	secret, err := GenerateClientSecretWithOptions(signingKey, ClientSecretOptions{
+		TeamID:   teamID,
+		ClientID: clientID,
+		KeyID:    keyID,
+		Clock:    clock,
+	})
 	if err != nil {
 		return "", err
 	}
+	return secret.Token, nil
+}
+
+// GenerateClientSecretWithOptions generates a client secret with a custom lifetime, issued-at time or
+// audience, and returns its expiry alongside the token so callers know when to sign a new one.
+func GenerateClientSecretWithOptions(signingKey string, opts ClientSecretOptions) (*ClientSecret, error) {
+	privKey, err := ParseSigningKey([]byte(signingKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return SignClientSecret(privKey, opts)
+}
+
+// validityWindow resolves the iat and exp of the secret and checks them against Apple's limits.
+// Both are truncated to whole seconds to match their JWT encoding.
+func (o ClientSecretOptions) validityWindow() (time.Time, time.Time, error) {
+	lifetime := o.Lifetime
+	switch {
+	case lifetime < 0:
+		return time.Time{}, time.Time{}, fmt.Errorf("client secret lifetime must be positive, got %s", lifetime)
+	case lifetime == 0:
+		lifetime = DefaultClientSecretLifetime
+	case lifetime > MaxClientSecretLifetime:
+		return time.Time{}, time.Time{}, fmt.Errorf("client secret lifetime %s exceeds Apple's maximum of %s", lifetime, MaxClientSecretLifetime)
+	}
 
-	// Create the Claims
-	now := time.Now()
-	claims := &jwt.RegisteredClaims{
-		Issuer: teamID,
-		IssuedAt: &jwt.NumericDate{
-			Time: now,
-		},
-		ExpiresAt: &jwt.NumericDate{
-			Time: now.Add(time.Hour*24*180 - time.Second), // 180 days
-		},
-		Audience: jwt.ClaimStrings{
-			"https://appleid.apple.com",
-		},
-		Subject: clientID,
+	now := clockOrDefault(o.Clock).Now()
+	issuedAt := o.IssuedAt
+	if issuedAt.IsZero() {
+		issuedAt = now
 	}
+	issuedAt = issuedAt.Truncate(time.Second)
+	expiresAt := issuedAt.Add(lifetime)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
-	token.Header["alg"] = "ES256"
-	token.Header["kid"] = keyID
+	// Apple measures the maximum lifetime from its own clock, not from iat
+	if !expiresAt.After(now) {
+		return time.Time{}, time.Time{}, fmt.Errorf("client secret would already be expired at %s", expiresAt.Format(time.RFC3339))
+	}
+	if expiresAt.Sub(now) > MaxClientSecretLifetime {
+		return time.Time{}, time.Time{}, fmt.Errorf("client secret expiry %s is more than %s in the future", expiresAt.Format(time.RFC3339), MaxClientSecretLifetime)
+	}
 
-	return token.SignedString(privKey)
+	return issuedAt, expiresAt, nil
+}
+
+func (o ClientSecretOptions) audience() string {
+	if o.Audience == "" {
+		return AppleIssuer
+	}
+	return o.Audience
 }
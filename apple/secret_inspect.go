@@ -0,0 +1,123 @@
+package apple
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidClientSecret is wrapped by errors from InspectClientSecret when a secret would be rejected by Apple
+var ErrInvalidClientSecret = errors.New("invalid client secret")
+
+// ClientSecretInfo describes a client secret JWT
+type ClientSecretInfo struct {
+	// TeamID is the iss claim
+	TeamID string
+
+	// ClientID is the sub claim
+	ClientID string
+
+	// KeyID is the kid header
+	KeyID string
+
+	// Audience is the aud claim
+	Audience string
+
+	// IssuedAt is the iat claim
+	IssuedAt time.Time
+
+	// ExpiresAt is the exp claim
+	ExpiresAt time.Time
+
+	// TimeToExpiry is the time left until ExpiresAt at inspection time. It is negative once the secret has expired.
+	TimeToExpiry time.Duration
+}
+
+// AppleAudience reports whether Audience is Apple's, as production secrets need. Secrets signed for a
+// sandbox or mock server with ClientSecretOptions.Audience have another audience and are not rejected.
+func (i *ClientSecretInfo) AppleAudience() bool {
+	return i.Audience == AppleIssuer
+}
+
+// Expired reports whether the secret had expired at inspection time
+func (i *ClientSecretInfo) Expired() bool {
+	return i.TimeToExpiry <= 0
+}
+
+// InspectClientSecret decodes a client secret, such as one generated by GenerateClientSecret and stored in
+// configuration, and reports who it identifies and when it expires. It is intended for expiry alerts and
+// startup checks.
+//
+// The secret's structure is checked against the rules GenerateClientSecret follows: an ES256 header with a kid,
+// iss and sub claims, a single aud claim, and iat/exp claims at most MaxClientSecretLifetime apart. All problems
+// are reported together in an error wrapping ErrInvalidClientSecret. The signature is not verified because
+// only Apple holds the public half of the key. An expired but otherwise well-formed secret is not an error;
+// check Expired or TimeToExpiry. Neither is an audience other than Apple's; check AppleAudience.
+func InspectClientSecret(clientSecret string) (*ClientSecretInfo, error) {
+	return InspectClientSecretWithClock(clientSecret, SystemClock)
+}
+
+// InspectClientSecretWithClock is InspectClientSecret with TimeToExpiry measured against clock.
+// A nil clock uses SystemClock.
+func InspectClientSecretWithClock(clientSecret string, clock Clock) (*ClientSecretInfo, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, _, err := new(jwt.Parser).ParseUnverified(clientSecret, claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClientSecret, err)
+	}
+
+	info := &ClientSecretInfo{
+		TeamID:   claims.Issuer,
+		ClientID: claims.Subject,
+	}
+	info.KeyID, _ = token.Header["kid"].(string)
+
+	var problems []error
+	if alg, _ := token.Header["alg"].(string); alg != "ES256" {
+		problems = append(problems, fmt.Errorf("alg header is %q, expected ES256", alg))
+	}
+	if info.KeyID == "" {
+		problems = append(problems, errors.New("missing kid header"))
+	}
+	if info.TeamID == "" {
+		problems = append(problems, errors.New("missing iss claim (team ID)"))
+	}
+	if info.ClientID == "" {
+		problems = append(problems, errors.New("missing sub claim (client ID)"))
+	}
+
+	switch len(claims.Audience) {
+	case 0:
+		problems = append(problems, errors.New("missing aud claim"))
+	case 1:
+		info.Audience = claims.Audience[0]
+	default:
+		problems = append(problems, fmt.Errorf("aud claim has %d values, expected one", len(claims.Audience)))
+	}
+
+	if claims.IssuedAt == nil {
+		problems = append(problems, errors.New("missing iat claim"))
+	} else {
+		info.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt == nil {
+		problems = append(problems, errors.New("missing exp claim"))
+	} else {
+		info.ExpiresAt = claims.ExpiresAt.Time
+		info.TimeToExpiry = info.ExpiresAt.Sub(clockOrDefault(clock).Now())
+	}
+	if claims.IssuedAt != nil && claims.ExpiresAt != nil {
+		if lifetime := info.ExpiresAt.Sub(info.IssuedAt); lifetime <= 0 {
+			problems = append(problems, errors.New("exp claim is not after iat claim"))
+		} else if lifetime > MaxClientSecretLifetime {
+			problems = append(problems, fmt.Errorf("lifetime %s exceeds Apple's maximum of %s", lifetime, MaxClientSecretLifetime))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClientSecret, errors.Join(problems...))
+	}
+	return info, nil
+}
@@ -0,0 +1,159 @@
+package apple
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectClientSecret(t *testing.T) {
+	issuedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(issuedAt)
+
+	secret, err := GenerateClientSecretWithClock(testSigningKey, "1234567890", "com.example.app", "0987654321", clock)
+	require.NoError(t, err)
+
+	clock.Advance(170 * 24 * time.Hour)
+	info, err := InspectClientSecretWithClock(secret, clock)
+	require.NoError(t, err)
+	assert.Equal(t, "1234567890", info.TeamID)
+	assert.Equal(t, "com.example.app", info.ClientID)
+	assert.Equal(t, "0987654321", info.KeyID)
+	assert.Equal(t, AppleIssuer, info.Audience)
+	assert.True(t, issuedAt.Equal(info.IssuedAt))
+	assert.True(t, issuedAt.Add(DefaultClientSecretLifetime).Equal(info.ExpiresAt))
+	assert.Equal(t, 10*24*time.Hour-time.Second, info.TimeToExpiry)
+	assert.False(t, info.Expired())
+
+	// An expired secret is still well formed
+	clock.Advance(30 * 24 * time.Hour)
+	info, err = InspectClientSecretWithClock(secret, clock)
+	require.NoError(t, err)
+	assert.True(t, info.Expired())
+	assert.Negative(t, int64(info.TimeToExpiry))
+
+	info, err = InspectClientSecret(secret)
+	require.NoError(t, err)
+	assert.True(t, info.Expired())
+	assert.True(t, info.AppleAudience())
+}
+
+func TestInspectClientSecretOtherAudience(t *testing.T) {
+	signer, err := GenerateSoftwareSigner()
+	require.NoError(t, err)
+	secret, err := SignClientSecret(signer, ClientSecretOptions{
+		TeamID:   "1234567890",
+		ClientID: "com.example.app",
+		KeyID:    "0987654321",
+		Audience: "https://sandbox.example.com",
+	})
+	require.NoError(t, err)
+
+	info, err := InspectClientSecret(secret.Token)
+	require.NoError(t, err, "secrets for a sandbox or mock server are well formed")
+	assert.Equal(t, "https://sandbox.example.com", info.Audience)
+	assert.False(t, info.AppleAudience())
+}
+
+func TestInspectClientSecretInvalid(t *testing.T) {
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": "1234567890",
+			"sub": "com.example.app",
+			"aud": AppleIssuer,
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	ecKey, err := ParseSigningKey([]byte(testSigningKey))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr []string
+	}{
+		{
+			name:    "not a jwt",
+			secret:  "not-a-jwt",
+			wantErr: []string{"invalid client secret"},
+		},
+		{
+			name:    "wrong algorithm and missing kid",
+			secret:  sign(jwt.SigningMethodHS256, []byte("secret"), "", valid()),
+			wantErr: []string{`alg header is "HS256"`, "missing kid"},
+		},
+		{
+			name: "swapped team and client",
+			secret: sign(jwt.SigningMethodES256, ecKey, "0987654321", func() jwt.MapClaims {
+				c := valid()
+				delete(c, "iss")
+				delete(c, "sub")
+				return c
+			}()),
+			wantErr: []string{"missing iss", "missing sub"},
+		},
+		{
+			name: "several audiences",
+			secret: sign(jwt.SigningMethodES256, ecKey, "0987654321", func() jwt.MapClaims {
+				c := valid()
+				c["aud"] = []string{AppleIssuer, "https://example.com"}
+				return c
+			}()),
+			wantErr: []string{"aud claim has 2 values"},
+		},
+		{
+			name: "lifetime too long",
+			secret: sign(jwt.SigningMethodES256, ecKey, "0987654321", func() jwt.MapClaims {
+				c := valid()
+				c["exp"] = now.Add(365 * 24 * time.Hour).Unix()
+				return c
+			}()),
+			wantErr: []string{"exceeds Apple's maximum"},
+		},
+		{
+			name: "missing timestamps",
+			secret: sign(jwt.SigningMethodES256, ecKey, "0987654321", func() jwt.MapClaims {
+				c := valid()
+				delete(c, "iat")
+				delete(c, "exp")
+				return c
+			}()),
+			wantErr: []string{"missing iat", "missing exp"},
+		},
+		{
+			name: "exp before iat",
+			secret: sign(jwt.SigningMethodES256, ecKey, "0987654321", func() jwt.MapClaims {
+				c := valid()
+				c["exp"] = now.Add(-time.Hour).Unix()
+				return c
+			}()),
+			wantErr: []string{"exp claim is not after iat"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := InspectClientSecret(tt.secret)
+			require.Error(t, err)
+			assert.Nil(t, info)
+			assert.ErrorIs(t, err, ErrInvalidClientSecret)
+			for _, want := range tt.wantErr {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package apple
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrAllSigningKeysRejected is returned by RotatingSecretProvider.Call when Apple rejected the secrets of every configured key.
+var ErrAllSigningKeysRejected = errors.New("apple rejected client secrets signed with every configured key")
+
+// SecretProvider supplies the client secret to send with requests for a client ID
+type SecretProvider interface {
+	ClientSecret(ctx context.Context, clientID string) (string, error)
+}
+
+// SecretFailover is implemented by SecretProviders that can move to another signing key. When Apple
+// rejects a secret supplied through ClientOptions.Secrets with invalid_client, the Client calls
+// SecretRejected and, if it returns true, retries the request once with a new secret.
+type SecretFailover interface {
+	// SecretRejected reports that Apple rejected clientSecret, and whether another secret is available
+	SecretRejected(ctx context.Context, clientID, clientSecret string) bool
+}
+
+// SigningKey pairs a 10-character Key ID from the Apple Developer portal with the signer holding its private key
+type SigningKey struct {
+	KeyID  string
+	Signer crypto.Signer
+}
+
+// FailoverEvent describes a switch from one signing key to the next after Apple rejected a client secret
+type FailoverEvent struct {
+	// ClientID is the client ID of the rejected request
+	ClientID string
+
+	// FailedKeyID is the key that signed the rejected secret
+	FailedKeyID string
+
+	// NextKeyID is the key that will be used from now on. It is empty when no keys remain.
+	NextKeyID string
+}
+
+// RotatingSecretProviderOptions configures a RotatingSecretProvider
+type RotatingSecretProviderOptions struct {
+	// TeamID is your 10-character Team ID
+	TeamID string
+
+	// Keys are the signing keys in order of preference. The first is the primary key.
+	Keys []SigningKey
+
+	// Lifetime is the lifetime of each generated secret. Defaults to DefaultClientSecretLifetime.
+	// Secrets are re-signed once less than a tenth of their lifetime remains.
+	Lifetime time.Duration
+
+	// Clock supplies the current time. Defaults to SystemClock.
+	Clock Clock
+
+	// OnFailover is called whenever Apple rejects a secret with invalid_client and the provider moves to the next key.
+	OnFailover func(FailoverEvent)
+}
+
+// RotatingSecretProvider signs client secrets with an ordered set of keys belonging to one team.
+//
+// Secrets are signed with the primary key and cached per client ID. When Apple answers a request made
+// through Call, or through a Client using the provider as ClientOptions.Secrets, with invalid_client,
+// the active key is demoted, OnFailover fires and the request is retried with the next key. Failover is
+// sticky so a revoked key is not retried on every request; call Reset once the primary key is known to
+// be good again.
+type RotatingSecretProvider struct {
+	teamID     string
+	keys       []SigningKey
+	lifetime   time.Duration
+	clock      Clock
+	onFailover func(FailoverEvent)
+
+	mu      sync.Mutex
+	active  int
+	secrets map[string]*ClientSecret
+}
+
+// NewRotatingSecretProvider creates a RotatingSecretProvider. At least one key is required.
+func NewRotatingSecretProvider(options RotatingSecretProviderOptions) (*RotatingSecretProvider, error) {
+	if options.TeamID == "" {
+		return nil, errors.New("team ID is required")
+	}
+	if len(options.Keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	for i, key := range options.Keys {
+		if key.KeyID == "" || key.Signer == nil {
+			return nil, fmt.Errorf("signing key %d must have a key ID and a signer", i)
+		}
+	}
+	if options.Lifetime == 0 {
+		options.Lifetime = DefaultClientSecretLifetime
+	}
+	if options.Lifetime < 0 || options.Lifetime > MaxClientSecretLifetime {
+		return nil, fmt.Errorf("client secret lifetime must be between 0 and %s, got %s", MaxClientSecretLifetime, options.Lifetime)
+	}
+
+	return &RotatingSecretProvider{
+		teamID:     options.TeamID,
+		keys:       append([]SigningKey(nil), options.Keys...),
+		lifetime:   options.Lifetime,
+		clock:      clockOrDefault(options.Clock),
+		onFailover: options.OnFailover,
+		secrets:    make(map[string]*ClientSecret),
+	}, nil
+}
+
+// ClientSecret returns a secret for clientID signed with the active key, reusing a cached secret while it is fresh.
+func (p *RotatingSecretProvider) ClientSecret(ctx context.Context, clientID string) (string, error) {
+	secret, _, err := p.secret(clientID)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ActiveKeyID returns the Key ID currently used for signing.
+func (p *RotatingSecretProvider) ActiveKeyID() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keys[p.active].KeyID
+}
+
+// Reset makes the primary key active again and discards cached secrets.
+func (p *RotatingSecretProvider) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = 0
+	p.secrets = make(map[string]*ClientSecret)
+}
+
+// Call invokes fn with a client secret for clientID. fn performs the request and returns Apple's error
+// code (e.g. ValidationResponse.Error) along with any transport error.
+//
+// When fn reports invalid_client, the key that signed the secret is demoted, OnFailover is invoked and
+// fn is retried with the next key. ErrAllSigningKeysRejected is returned once every key has been tried.
+// Other Apple errors are left for the caller to inspect in its response, matching the Verify methods.
+func (p *RotatingSecretProvider) Call(ctx context.Context, clientID string, fn func(clientSecret string) (appleError string, err error)) error {
+	for attempt := 0; attempt < len(p.keys); attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		secret, keyIndex, err := p.secret(clientID)
+		if err != nil {
+			return err
+		}
+
+		appleError, err := fn(secret)
+		if err != nil {
+			return err
+		}
+		if appleError != "invalid_client" {
+			return nil
+		}
+
+		if !p.failover(clientID, keyIndex) {
+			break
+		}
+	}
+	return ErrAllSigningKeysRejected
+}
+
+// SecretRejected demotes the key that signed clientSecret, implementing SecretFailover. It reports
+// false when no other key remains or clientSecret was not signed by one of the provider's keys.
+func (p *RotatingSecretProvider) SecretRejected(ctx context.Context, clientID, clientSecret string) bool {
+	token, _, err := jwt.NewParser().ParseUnverified(clientSecret, jwt.MapClaims{})
+	if err != nil {
+		return false
+	}
+	kid, _ := token.Header["kid"].(string)
+	for i, key := range p.keys {
+		if key.KeyID == kid {
+			return p.failover(clientID, i)
+		}
+	}
+	return false
+}
+
+// secret returns a cached or freshly signed secret for clientID and the index of the key that signed it.
+func (p *RotatingSecretProvider) secret(clientID string) (string, int, error) {
+	now := p.clock.Now()
+
+	p.mu.Lock()
+	index := p.active
+	cached, ok := p.secrets[clientID]
+	p.mu.Unlock()
+
+	if ok && cached.ExpiresAt.Sub(now) > p.lifetime/10 {
+		return cached.Token, index, nil
+	}
+
+	key := p.keys[index]
+	secret, err := SignClientSecret(key.Signer, ClientSecretOptions{
+		TeamID:   p.teamID,
+		ClientID: clientID,
+		KeyID:    key.KeyID,
+		Lifetime: p.lifetime,
+		Clock:    p.clock,
+	})
+	if err != nil {
+		return "", index, fmt.Errorf("signing client secret with key %s: %w", key.KeyID, err)
+	}
+
+	p.mu.Lock()
+	// Only cache if no failover happened while signing
+	if p.active == index {
+		p.secrets[clientID] = secret
+	}
+	p.mu.Unlock()
+
+	return secret.Token, index, nil
+}
+
+// failover demotes the key at keyIndex and reports whether another key is available.
+func (p *RotatingSecretProvider) failover(clientID string, keyIndex int) bool {
+	p.mu.Lock()
+	if p.active != keyIndex {
+		// Another request already moved on from this key
+		p.mu.Unlock()
+		return true
+	}
+	event := FailoverEvent{
+		ClientID:    clientID,
+		FailedKeyID: p.keys[keyIndex].KeyID,
+	}
+	next := keyIndex+1 < len(p.keys)
+	if next {
+		p.active = keyIndex + 1
+		p.secrets = make(map[string]*ClientSecret)
+		event.NextKeyID = p.keys[p.active].KeyID
+	}
+	p.mu.Unlock()
+
+	if p.onFailover != nil {
+		p.onFailover(event)
+	}
+	return next
+}
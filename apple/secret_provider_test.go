@@ -0,0 +1,264 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigningKeys(t *testing.T, keyIDs ...string) []SigningKey {
+	t.Helper()
+	keys := make([]SigningKey, 0, len(keyIDs))
+	for _, id := range keyIDs {
+		signer, err := GenerateSoftwareSigner()
+		require.NoError(t, err)
+		keys = append(keys, SigningKey{KeyID: id, Signer: signer})
+	}
+	return keys
+}
+
+func secretKeyID(t *testing.T, secret string) string {
+	t.Helper()
+	token, _, err := new(jwt.Parser).ParseUnverified(secret, jwt.MapClaims{})
+	require.NoError(t, err)
+	return token.Header["kid"].(string)
+}
+
+func TestNewRotatingSecretProviderValidation(t *testing.T) {
+	keys := newTestSigningKeys(t, "KEY0000001")
+
+	_, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{Keys: keys})
+	assert.Error(t, err, "team ID is required")
+
+	_, err = NewRotatingSecretProvider(RotatingSecretProviderOptions{TeamID: "TEAM000001"})
+	assert.Error(t, err, "keys are required")
+
+	_, err = NewRotatingSecretProvider(RotatingSecretProviderOptions{TeamID: "TEAM000001", Keys: []SigningKey{{KeyID: "KEY0000001"}}})
+	assert.Error(t, err, "signer is required")
+
+	_, err = NewRotatingSecretProvider(RotatingSecretProviderOptions{TeamID: "TEAM000001", Keys: keys, Lifetime: 365 * 24 * time.Hour})
+	assert.Error(t, err, "lifetime over the maximum is rejected")
+}
+
+func TestRotatingSecretProviderCachesSecrets(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	p, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{
+		TeamID:   "TEAM000001",
+		Keys:     newTestSigningKeys(t, "KEY0000001", "KEY0000002"),
+		Lifetime: 10 * time.Hour,
+		Clock:    clock,
+	})
+	require.NoError(t, err)
+
+	first, err := p.ClientSecret(context.Background(), "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, "KEY0000001", secretKeyID(t, first), "the primary key signs by default")
+
+	other, err := p.ClientSecret(context.Background(), "com.example.web")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, other, "secrets are per client ID")
+
+	clock.Advance(8 * time.Hour)
+	cached, err := p.ClientSecret(context.Background(), "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, first, cached, "fresh secrets are reused")
+
+	clock.Advance(90 * time.Minute)
+	renewed, err := p.ClientSecret(context.Background(), "com.example.app")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, renewed, "secrets are re-signed before they expire")
+}
+
+func TestRotatingSecretProviderFailover(t *testing.T) {
+	// Apple rejects secrets signed with the revoked key
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if secretKeyID(t, r.PostForm.Get("client_secret")) == "REVOKED001" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"a","id_token":"i"}`))
+	}))
+	defer srv.Close()
+
+	var events []FailoverEvent
+	p, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{
+		TeamID:     "TEAM000001",
+		Keys:       newTestSigningKeys(t, "REVOKED001", "GOODKEY001"),
+		OnFailover: func(e FailoverEvent) { events = append(events, e) },
+	})
+	require.NoError(t, err)
+
+	client := NewWithOptions(ClientOptions{ValidationURL: srv.URL})
+
+	var resp ValidationResponse
+	err = p.Call(context.Background(), "com.example.app", func(secret string) (string, error) {
+		resp = ValidationResponse{}
+		err := client.VerifyAppToken(context.Background(), AppValidationTokenRequest{
+			ClientID:     "com.example.app",
+			ClientSecret: secret,
+			Code:         "code",
+		}, &resp)
+		return resp.Error, err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "a", resp.AccessToken)
+	assert.Equal(t, "GOODKEY001", p.ActiveKeyID())
+	require.Len(t, events, 1)
+	assert.Equal(t, FailoverEvent{ClientID: "com.example.app", FailedKeyID: "REVOKED001", NextKeyID: "GOODKEY001"}, events[0])
+
+	// Failover is sticky until Reset
+	secret, err := p.ClientSecret(context.Background(), "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, "GOODKEY001", secretKeyID(t, secret))
+
+	p.Reset()
+	assert.Equal(t, "REVOKED001", p.ActiveKeyID())
+}
+
+func TestRotatingSecretProviderAsClientSecrets(t *testing.T) {
+	var requests atomic.Int32
+	var goodKeyRevoked atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		requests.Add(1)
+		kid := ""
+		if token, _, err := new(jwt.Parser).ParseUnverified(r.PostForm.Get("client_secret"), jwt.MapClaims{}); err == nil {
+			kid, _ = token.Header["kid"].(string)
+		}
+		if kid != "GOODKEY001" || goodKeyRevoked.Load() {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"a","id_token":"i"}`))
+	}))
+	defer srv.Close()
+
+	var events []FailoverEvent
+	p, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{
+		TeamID:     "TEAM000001",
+		Keys:       newTestSigningKeys(t, "REVOKED001", "GOODKEY001"),
+		OnFailover: func(e FailoverEvent) { events = append(events, e) },
+	})
+	require.NoError(t, err)
+	client := NewWithOptions(ClientOptions{ValidationURL: srv.URL, RevokeURL: srv.URL, Secrets: p})
+
+	var resp ValidationResponse
+	require.NoError(t, client.VerifyAppToken(context.Background(), AppValidationTokenRequest{ClientID: "com.example.app", Code: "code"}, &resp))
+	assert.Equal(t, "a", resp.AccessToken, "the request is retried with the next key")
+	assert.Equal(t, "GOODKEY001", p.ActiveKeyID())
+	require.Len(t, events, 1)
+	assert.Equal(t, int32(2), requests.Load())
+
+	_, err = client.Typed().RevokeRefreshToken(context.Background(), RevokeRefreshTokenRequest{ClientID: "com.example.app", RefreshToken: "r"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), requests.Load(), "the demoted key is not tried again")
+
+	// Once every key is rejected the error reaches the caller after a single retry
+	p.Reset()
+	goodKeyRevoked.Store(true)
+	requests.Store(0)
+	_, err = client.Typed().VerifyAppToken(context.Background(), AppValidationTokenRequest{ClientID: "com.example.app", Code: "code"})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "invalid_client", apiErr.Code)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Secrets given in the request are the caller's and are not failed over
+	requests.Store(0)
+	_, err = client.Typed().VerifyAppToken(context.Background(), AppValidationTokenRequest{ClientID: "com.example.app", ClientSecret: "not-a-jwt", Code: "code"})
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestRotatingSecretProviderCall(t *testing.T) {
+	newProvider := func(t *testing.T, events *[]FailoverEvent) *RotatingSecretProvider {
+		p, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{
+			TeamID:     "TEAM000001",
+			Keys:       newTestSigningKeys(t, "KEY0000001", "KEY0000002"),
+			OnFailover: func(e FailoverEvent) { *events = append(*events, e) },
+		})
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("all keys rejected", func(t *testing.T) {
+		var events []FailoverEvent
+		p := newProvider(t, &events)
+		calls := 0
+		err := p.Call(context.Background(), "com.example.app", func(string) (string, error) {
+			calls++
+			return "invalid_client", nil
+		})
+		assert.ErrorIs(t, err, ErrAllSigningKeysRejected)
+		assert.Equal(t, 2, calls)
+		require.Len(t, events, 2)
+		assert.Empty(t, events[1].NextKeyID)
+	})
+
+	t.Run("other apple errors do not fail over", func(t *testing.T) {
+		var events []FailoverEvent
+		p := newProvider(t, &events)
+		err := p.Call(context.Background(), "com.example.app", func(string) (string, error) {
+			return "invalid_grant", nil
+		})
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+		assert.Equal(t, "KEY0000001", p.ActiveKeyID())
+	})
+
+	t.Run("transport errors are returned without failover", func(t *testing.T) {
+		var events []FailoverEvent
+		p := newProvider(t, &events)
+		boom := errors.New("boom")
+		err := p.Call(context.Background(), "com.example.app", func(string) (string, error) {
+			return "", boom
+		})
+		assert.ErrorIs(t, err, boom)
+		assert.Empty(t, events)
+	})
+
+	t.Run("concurrent rejections fail over once", func(t *testing.T) {
+		var mu sync.Mutex
+		var events []FailoverEvent
+		p, err := NewRotatingSecretProvider(RotatingSecretProviderOptions{
+			TeamID: "TEAM000001",
+			Keys:   newTestSigningKeys(t, "KEY0000001", "KEY0000002"),
+			OnFailover: func(e FailoverEvent) {
+				mu.Lock()
+				events = append(events, e)
+				mu.Unlock()
+			},
+		})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := p.Call(context.Background(), "com.example.app", func(secret string) (string, error) {
+					if secretKeyID(t, secret) == "KEY0000001" {
+						return "invalid_client", nil
+					}
+					return "", nil
+				})
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Len(t, events, 1)
+		assert.Equal(t, "KEY0000002", p.ActiveKeyID())
+	})
+}
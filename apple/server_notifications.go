@@ -21,7 +21,8 @@ import (
 func (c *Client) ParseServerNotification(ctx context.Context, jwtPayload string) (*ServerNotificationClaims, error) {
 	var m jwt.MapClaims
 
-	if c.skipVerify {
+	cfg := c.config.Load()
+	if cfg.skipVerify {
 		token, _, err := new(jwt.Parser).ParseUnverified(jwtPayload, jwt.MapClaims{})
 		if err != nil {
 			return nil, err
@@ -40,10 +41,11 @@ func (c *Client) ParseServerNotification(ctx context.Context, jwtPayload string)
 			if !ok {
 				return nil, fmt.Errorf("missing kid in token header")
 			}
-			return c.getPublicKey(ctx, kid)
+			return c.getPublicKey(ctx, cfg, kid)
 		},
-			jwt.WithIssuer(AppleIssuer),
+			jwt.WithIssuer(cfg.issuer),
 			jwt.WithExpirationRequired(),
+			jwt.WithTimeFunc(cfg.clock.Now),
 			// aud is not validated here because ParseServerNotification has no clientID
 			// parameter — the caller registers a single webhook endpoint for all apps.
 		)
@@ -0,0 +1,154 @@
+package apple
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// es256CoordinateSize is the byte length of r and s in a JOSE ES256 signature
+const es256CoordinateSize = 32
+
+// SignClientSecret generates a client secret like GenerateClientSecretWithOptions, but signs it
+// through signer instead of a PEM key held in memory. This lets the .p8 key live in a KMS or HSM:
+// any crypto.Signer backed by an ECDSA P-256 key that returns ASN.1 DER signatures, as the
+// crypto.Signer contract requires, can be used.
+func SignClientSecret(signer crypto.Signer, opts ClientSecretOptions) (*ClientSecret, error) {
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("signer must hold an ECDSA P-256 key, got %T", signer.Public())
+	}
+
+	issuedAt, expiresAt, err := opts.validityWindow()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the Claims
+	claims := &jwt.RegisteredClaims{
+		Issuer: opts.TeamID,
+		IssuedAt: &jwt.NumericDate{
+			Time: issuedAt,
+		},
+		ExpiresAt: &jwt.NumericDate{
+			Time: expiresAt,
+		},
+		Audience: jwt.ClaimStrings{
+			opts.audience(),
+		},
+		Subject: opts.ClientID,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["alg"] = "ES256"
+	token.Header["kid"] = opts.KeyID
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(signingString))
+	der, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign client secret: %w", err)
+	}
+
+	// Catch signers that return the wrong format or sign with a different key than they advertise
+	if !ecdsa.VerifyASN1(pub, digest[:], der) {
+		return nil, errors.New("signer returned a signature that does not verify against its public key")
+	}
+
+	sig, err := derToJOSESignature(der, es256CoordinateSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientSecret{
+		Token:     signingString + "." + base64.RawURLEncoding.EncodeToString(sig),
+		IssuedAt:  issuedAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// derToJOSESignature converts an ASN.1 DER ECDSA signature into the fixed-length r||s
+// form required by JWS (RFC 7518 section 3.4).
+func derToJOSESignature(der []byte, size int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ASN.1 ECDSA signature: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("invalid ASN.1 ECDSA signature: trailing data")
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return nil, errors.New("invalid ASN.1 ECDSA signature: non-positive r or s")
+	}
+	if sig.R.BitLen() > size*8 || sig.S.BitLen() > size*8 {
+		return nil, errors.New("invalid ASN.1 ECDSA signature: r or s too large")
+	}
+
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+	return out, nil
+}
+
+// SoftwareSigner is an in-memory crypto.Signer for an ECDSA P-256 key. It is the reference
+// implementation used by GenerateClientSecretWithOptions and is intended for tests and local
+// development in place of a KMS or HSM backed signer.
+type SoftwareSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewSoftwareSigner creates a SoftwareSigner from the .p8 key downloaded from the Apple Developer portal,
+// in any of the formats accepted by ParseSigningKey.
+func NewSoftwareSigner(signingKey string) (*SoftwareSigner, error) {
+	key, err := ParseSigningKey([]byte(signingKey))
+	if err != nil {
+		return nil, err
+	}
+	return &SoftwareSigner{key: key}, nil
+}
+
+// NewSoftwareSignerFromKey creates a SoftwareSigner from an already parsed key, such as one returned
+// by LoadSigningKeyFile.
+func NewSoftwareSignerFromKey(key *ecdsa.PrivateKey) (*SoftwareSigner, error) {
+	if _, err := checkSigningKeyCurve(key); err != nil {
+		return nil, err
+	}
+	return &SoftwareSigner{key: key}, nil
+}
+
+// GenerateSoftwareSigner creates a SoftwareSigner with a freshly generated P-256 key. Secrets it signs
+// will not be accepted by Apple; it is meant for tests.
+func GenerateSoftwareSigner() (*SoftwareSigner, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &SoftwareSigner{key: key}, nil
+}
+
+// Public returns the *ecdsa.PublicKey of the signer.
+func (s *SoftwareSigner) Public() crypto.PublicKey {
+	return &s.key.PublicKey
+}
+
+// Sign returns an ASN.1 DER ECDSA signature of digest.
+func (s *SoftwareSigner) Sign(rand io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return ecdsa.SignASN1(rand, s.key, digest)
+}
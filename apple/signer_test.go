@@ -0,0 +1,129 @@
+package apple
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawSigner is a misbehaving signer that returns r||s instead of ASN.1 DER
+type rawSigner struct {
+	*SoftwareSigner
+}
+
+func (s rawSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	der, err := s.SoftwareSigner.Sign(rand, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	return derToJOSESignature(der, es256CoordinateSize)
+}
+
+func TestSignClientSecret(t *testing.T) {
+	signer, err := GenerateSoftwareSigner()
+	require.NoError(t, err)
+
+	now := time.Now()
+	secret, err := SignClientSecret(signer, ClientSecretOptions{
+		TeamID:   "1234567890",
+		ClientID: "com.example.app",
+		KeyID:    "0987654321",
+		Lifetime: time.Hour,
+	})
+	require.NoError(t, err)
+	assert.WithinDuration(t, now.Add(time.Hour), secret.ExpiresAt, 2*time.Second)
+
+	// The JOSE signature must verify with a standard ES256 implementation
+	token, err := jwt.Parse(secret.Token, func(token *jwt.Token) (interface{}, error) {
+		return signer.Public(), nil
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	require.NoError(t, err)
+	assert.Equal(t, "0987654321", token.Header["kid"])
+
+	claims := token.Claims.(jwt.MapClaims)
+	assert.Equal(t, "1234567890", claims["iss"])
+	assert.Equal(t, "com.example.app", claims["sub"])
+}
+
+func TestSignClientSecretRejectsBadSigners(t *testing.T) {
+	opts := ClientSecretOptions{TeamID: "1234567890", ClientID: "com.example.app", KeyID: "0987654321"}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = SignClientSecret(rsaKey, opts)
+	assert.Error(t, err, "RSA signers are not valid for ES256")
+
+	soft, err := GenerateSoftwareSigner()
+	require.NoError(t, err)
+	_, err = SignClientSecret(rawSigner{soft}, opts)
+	assert.Error(t, err, "signers must return ASN.1 DER signatures")
+
+	_, err = SignClientSecret(soft, ClientSecretOptions{Lifetime: -time.Second})
+	assert.Error(t, err)
+}
+
+func TestGenerateClientSecretMatchesSoftwareSigner(t *testing.T) {
+	signer, err := NewSoftwareSigner(testSigningKey)
+	require.NoError(t, err)
+
+	secret, err := GenerateClientSecret(testSigningKey, "1234567890", "com.example.app", "0987654321")
+	require.NoError(t, err)
+
+	_, err = jwt.Parse(secret, func(token *jwt.Token) (interface{}, error) {
+		return signer.Public(), nil
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	assert.NoError(t, err)
+
+	_, err = NewSoftwareSigner("bad_key")
+	assert.Error(t, err)
+}
+
+func TestDERToJOSESignature(t *testing.T) {
+	encode := func(r, s *big.Int) []byte {
+		der, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+		require.NoError(t, err)
+		return der
+	}
+
+	// Small values are left-padded to the coordinate size
+	out, err := derToJOSESignature(encode(big.NewInt(1), big.NewInt(2)), 32)
+	require.NoError(t, err)
+	require.Len(t, out, 64)
+	assert.Equal(t, byte(1), out[31])
+	assert.Equal(t, byte(2), out[63])
+
+	tooLarge := new(big.Int).Lsh(big.NewInt(1), 256)
+	_, err = derToJOSESignature(encode(tooLarge, big.NewInt(1)), 32)
+	assert.Error(t, err)
+
+	_, err = derToJOSESignature(encode(big.NewInt(0), big.NewInt(1)), 32)
+	assert.Error(t, err)
+
+	_, err = derToJOSESignature(append(encode(big.NewInt(1), big.NewInt(1)), 0), 32)
+	assert.Error(t, err)
+
+	_, err = derToJOSESignature([]byte("garbage"), 32)
+	assert.Error(t, err)
+
+	// Round trip against a real signature
+	key, err := GenerateSoftwareSigner()
+	require.NoError(t, err)
+	digest := make([]byte, 32)
+	der, err := key.Sign(rand.Reader, digest, crypto.SHA256)
+	require.NoError(t, err)
+	jose, err := derToJOSESignature(der, 32)
+	require.NoError(t, err)
+	r := new(big.Int).SetBytes(jose[:32])
+	s := new(big.Int).SetBytes(jose[32:])
+	assert.True(t, ecdsa.Verify(key.Public().(*ecdsa.PublicKey), digest, r, s))
+}
@@ -0,0 +1,173 @@
+package apple
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ErrInvalidSigningKey is wrapped by every error returned when a signing key cannot be used for Sign in with Apple.
+var ErrInvalidSigningKey = errors.New("invalid signing key")
+
+// ParseSigningKey decodes a Sign in with Apple private key and checks that it is an ECDSA P-256 key.
+//
+// It accepts the PKCS#8 PEM downloaded from the Apple Developer portal (.p8), SEC1 "EC PRIVATE KEY"
+// PEM blocks, PEM with escaped "\n" sequences as commonly found in environment variables, and
+// base64-wrapped PEM or DER as commonly stored in CI secrets. Errors explain what was found instead,
+// for example an RSA key or a PEM block whose line breaks were lost.
+func ParseSigningKey(data []byte) (*ecdsa.PrivateKey, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: key is empty", ErrInvalidSigningKey)
+	}
+
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		decoded, err := decodeBase64Key(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key is neither PEM nor base64; expected the contents of the .p8 file", ErrInvalidSigningKey)
+		}
+		if bytes.Contains(decoded, []byte("-----BEGIN")) {
+			return ParseSigningKey(decoded)
+		}
+		return parseDERSigningKey(decoded)
+	}
+
+	text := string(data)
+	if strings.Contains(text, `\n`) && !strings.Contains(text, "\n") {
+		// Escaped newlines, typically from a single-line environment variable or JSON string
+		text = strings.ReplaceAll(text, `\n`, "\n")
+	}
+	if strings.Contains(text, "\r") && !strings.Contains(text, "\n") {
+		return nil, fmt.Errorf("%w: PEM has Windows line endings stripped incorrectly (only \\r remains between lines); convert line endings to \\n", ErrInvalidSigningKey)
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	rest := []byte(text)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			if !strings.Contains(strings.TrimSpace(text), "\n") {
+				return nil, fmt.Errorf("%w: PEM is on a single line; its line breaks were stripped. Restore the newlines after the BEGIN line, within the body and before the END line", ErrInvalidSigningKey)
+			}
+			return nil, fmt.Errorf("%w: no valid PEM block found; check the BEGIN and END lines are intact", ErrInvalidSigningKey)
+		}
+
+		switch block.Type {
+		case "EC PARAMETERS":
+			// Written by "openssl ecparam -genkey" ahead of the key itself
+			continue
+		case "PRIVATE KEY", "EC PRIVATE KEY":
+			return parseDERSigningKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			return nil, errRSASigningKey()
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, fmt.Errorf("%w: key is encrypted; decrypt it first (Apple .p8 keys are not encrypted)", ErrInvalidSigningKey)
+		case "PUBLIC KEY":
+			return nil, fmt.Errorf("%w: this is a public key; the private .p8 key is required", ErrInvalidSigningKey)
+		case "CERTIFICATE":
+			return nil, fmt.Errorf("%w: this is a certificate; the private .p8 key is required", ErrInvalidSigningKey)
+		default:
+			return nil, fmt.Errorf("%w: unexpected PEM block %q", ErrInvalidSigningKey, block.Type)
+		}
+	}
+}
+
+// LoadSigningKeyFile reads and parses the signing key at path, typically the AuthKey_XXXXXXXXXX.p8 file.
+func LoadSigningKeyFile(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseSigningKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// LoadSigningKeyFS reads and parses the signing key name from fsys, e.g. an embed.FS.
+func LoadSigningKeyFS(fsys fs.FS, name string) (*ecdsa.PrivateKey, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseSigningKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return key, nil
+}
+
+// LoadSigningKeyEnv parses the signing key held in the environment variable name.
+func LoadSigningKeyEnv(name string) (*ecdsa.PrivateKey, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, fmt.Errorf("%w: environment variable %s is not set", ErrInvalidSigningKey, name)
+	}
+	key, err := ParseSigningKey([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("$%s: %w", name, err)
+	}
+	return key, nil
+}
+
+// parseDERSigningKey parses a PKCS#8 or SEC1 DER key and checks it is ECDSA P-256.
+func parseDERSigningKey(der []byte) (*ecdsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		switch k := key.(type) {
+		case *ecdsa.PrivateKey:
+			return checkSigningKeyCurve(k)
+		case *rsa.PrivateKey:
+			return nil, errRSASigningKey()
+		case ed25519.PrivateKey:
+			return nil, fmt.Errorf("%w: this is an Ed25519 key; Sign in with Apple requires the ECDSA P-256 .p8 key", ErrInvalidSigningKey)
+		default:
+			return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidSigningKey, key)
+		}
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return checkSigningKeyCurve(key)
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return nil, errRSASigningKey()
+	}
+	return nil, fmt.Errorf("%w: key data is not a PKCS#8 or SEC1 private key", ErrInvalidSigningKey)
+}
+
+func checkSigningKeyCurve(key *ecdsa.PrivateKey) (*ecdsa.PrivateKey, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("%w: key uses curve %s; Sign in with Apple requires P-256", ErrInvalidSigningKey, key.Curve.Params().Name)
+	}
+	return key, nil
+}
+
+func errRSASigningKey() error {
+	return fmt.Errorf("%w: this is an RSA key; Sign in with Apple requires the ECDSA P-256 .p8 key from the Keys section of the Apple Developer portal", ErrInvalidSigningKey)
+}
+
+// decodeBase64Key decodes standard or URL-safe base64, with or without padding, ignoring whitespace.
+func decodeBase64Key(data []byte) ([]byte, error) {
+	compact := strings.Join(strings.Fields(string(data)), "")
+	var firstErr error
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		decoded, err := enc.DecodeString(compact)
+		if err == nil {
+			return decoded, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
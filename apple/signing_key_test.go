@@ -0,0 +1,149 @@
+package apple
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}))
+}
+
+func TestParseSigningKey(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(p256)
+	require.NoError(t, err)
+	sec1, err := x509.MarshalECPrivateKey(p256)
+	require.NoError(t, err)
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	p384DER, err := x509.MarshalPKCS8PrivateKey(p384)
+	require.NoError(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&p256.PublicKey)
+	require.NoError(t, err)
+
+	p8 := encodePEM(t, "PRIVATE KEY", pkcs8)
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "pkcs8 pem", input: p8},
+		{name: "apple test key", input: testSigningKey},
+		{name: "sec1 pem", input: encodePEM(t, "EC PRIVATE KEY", sec1)},
+		{name: "sec1 pem with ec parameters", input: encodePEM(t, "EC PARAMETERS", []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}) + encodePEM(t, "EC PRIVATE KEY", sec1)},
+		{name: "windows line endings", input: strings.ReplaceAll(p8, "\n", "\r\n")},
+		{name: "escaped newlines", input: strings.ReplaceAll(p8, "\n", `\n`)},
+		{name: "base64-wrapped pem", input: base64.StdEncoding.EncodeToString([]byte(p8))},
+		{name: "base64 der", input: base64.StdEncoding.EncodeToString(pkcs8)},
+		{name: "surrounding whitespace", input: "\n\n  " + p8 + "  \n"},
+		{name: "empty", input: "  ", wantErr: "empty"},
+		{name: "garbage", input: "bad_key!", wantErr: "neither PEM nor base64"},
+		{name: "rsa pkcs1", input: encodePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), wantErr: "this is an RSA key"},
+		{name: "rsa pkcs8", input: encodePEM(t, "PRIVATE KEY", rsaPKCS8), wantErr: "this is an RSA key"},
+		{name: "rsa pkcs1 mislabelled", input: encodePEM(t, "PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), wantErr: "this is an RSA key"},
+		{name: "p-384", input: encodePEM(t, "PRIVATE KEY", p384DER), wantErr: "P-384"},
+		{name: "ed25519", input: encodePEM(t, "PRIVATE KEY", edDER), wantErr: "Ed25519"},
+		{name: "public key", input: encodePEM(t, "PUBLIC KEY", pubDER), wantErr: "public key"},
+		{name: "encrypted", input: encodePEM(t, "ENCRYPTED PRIVATE KEY", []byte{1}), wantErr: "encrypted"},
+		{name: "carriage returns only", input: strings.ReplaceAll(p8, "\n", "\r"), wantErr: "Windows line endings stripped incorrectly"},
+		{name: "single line", input: strings.ReplaceAll(p8, "\n", " "), wantErr: "single line"},
+		{name: "truncated", input: strings.SplitN(p8, "\n", 3)[0] + "\n" + strings.SplitN(p8, "\n", 3)[1], wantErr: "no valid PEM block"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseSigningKey([]byte(tt.input))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.ErrorIs(t, err, ErrInvalidSigningKey)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, elliptic.P256(), key.Curve)
+		})
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "AuthKey_0987654321.p8")
+		require.NoError(t, os.WriteFile(path, []byte(testSigningKey), 0o600))
+
+		key, err := LoadSigningKeyFile(path)
+		require.NoError(t, err)
+		assert.NotNil(t, key)
+
+		_, err = LoadSigningKeyFile(filepath.Join(t.TempDir(), "missing.p8"))
+		assert.Error(t, err)
+	})
+
+	t.Run("fs", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"keys/good.p8": {Data: []byte(testSigningKey)},
+			"keys/bad.p8":  {Data: []byte("bad")},
+		}
+
+		key, err := LoadSigningKeyFS(fsys, "keys/good.p8")
+		require.NoError(t, err)
+		assert.NotNil(t, key)
+
+		_, err = LoadSigningKeyFS(fsys, "keys/bad.p8")
+		assert.ErrorIs(t, err, ErrInvalidSigningKey)
+		assert.Contains(t, err.Error(), "keys/bad.p8")
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("TEST_APPLE_KEY", base64.StdEncoding.EncodeToString([]byte(testSigningKey)))
+		key, err := LoadSigningKeyEnv("TEST_APPLE_KEY")
+		require.NoError(t, err)
+		assert.NotNil(t, key)
+
+		_, err = LoadSigningKeyEnv("TEST_APPLE_KEY_UNSET")
+		assert.ErrorIs(t, err, ErrInvalidSigningKey)
+		assert.Contains(t, err.Error(), "TEST_APPLE_KEY_UNSET")
+	})
+}
+
+func TestNewSoftwareSignerFromKey(t *testing.T) {
+	key, err := ParseSigningKey([]byte(testSigningKey))
+	require.NoError(t, err)
+	signer, err := NewSoftwareSignerFromKey(key)
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(signer.Public()))
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, err = NewSoftwareSignerFromKey(p384)
+	assert.ErrorIs(t, err, ErrInvalidSigningKey)
+}
@@ -0,0 +1,268 @@
+package apple
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// envelopePrefix marks refresh tokens encrypted by EncryptedTokenStore and versions their format
+const envelopePrefix = "v1."
+
+// TokenDecryptionError reports a stored token an EncryptedTokenStore could not decrypt, because it is
+// corrupt or was encrypted under a key-encryption key that is no longer available. Range and
+// ListSubject skip such tokens and return one TokenDecryptionError per token, joined together.
+type TokenDecryptionError struct {
+	Subject  string
+	ClientID string
+	Err      error
+}
+
+func (e *TokenDecryptionError) Error() string {
+	return fmt.Sprintf("decrypting token of %s for %s: %v", e.Subject, e.ClientID, e.Err)
+}
+
+func (e *TokenDecryptionError) Unwrap() error {
+	return e.Err
+}
+
+// splitDecryptionErrors separates the TokenDecryptionErrors in err, which may be joined, from the rest
+func splitDecryptionErrors(err error) ([]*TokenDecryptionError, error) {
+	if err == nil {
+		return nil, nil
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+
+	var decryption []*TokenDecryptionError
+	var rest []error
+	for _, err := range errs {
+		var decryptionErr *TokenDecryptionError
+		if errors.As(err, &decryptionErr) {
+			decryption = append(decryption, decryptionErr)
+		} else {
+			rest = append(rest, err)
+		}
+	}
+	return decryption, errors.Join(rest...)
+}
+
+// KeyEncryptionKey wraps and unwraps the per-token data keys of an EncryptedTokenStore. Implement it
+// with a KMS or HSM to keep the key-encryption key out of the process; AESKeyEncryptionKey holds it locally.
+type KeyEncryptionKey interface {
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// AESKeyEncryptionKey is a KeyEncryptionKey that wraps data keys with AES-GCM under a local key
+type AESKeyEncryptionKey struct {
+	aead cipher.AEAD
+}
+
+// NewAESKeyEncryptionKey creates an AESKeyEncryptionKey from a 16, 24 or 32 byte key
+func NewAESKeyEncryptionKey(key []byte) (*AESKeyEncryptionKey, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AESKeyEncryptionKey{aead: aead}, nil
+}
+
+// WrapKey encrypts dataKey
+func (k *AESKeyEncryptionKey) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return sealGCM(k.aead, dataKey, nil)
+}
+
+// UnwrapKey decrypts a key wrapped by WrapKey
+func (k *AESKeyEncryptionKey) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return openGCM(k.aead, wrapped, nil)
+}
+
+// EncryptedTokenStore encrypts refresh and access tokens before handing them to another TokenStore.
+//
+// Each token is sealed with AES-256-GCM under a fresh data key, and the data key is wrapped by the
+// KeyEncryptionKey and stored alongside it. The field name, subject and client ID are bound as additional
+// data, so a ciphertext copied onto another user's record, or swapped between the refresh and access
+// token fields, fails to decrypt. Subjects, client IDs and timestamps
+// are stored in the clear.
+type EncryptedTokenStore struct {
+	store TokenStore
+	kek   KeyEncryptionKey
+}
+
+// NewEncryptedTokenStore wraps store so refresh tokens are encrypted at rest with kek
+func NewEncryptedTokenStore(store TokenStore, kek KeyEncryptionKey) *EncryptedTokenStore {
+	return &EncryptedTokenStore{store: store, kek: kek}
+}
+
+// Put encrypts the refresh and access tokens and stores token
+func (s *EncryptedTokenStore) Put(ctx context.Context, token StoredToken) error {
+	var err error
+	if token.RefreshToken, err = s.encrypt(ctx, "refresh_token", token, token.RefreshToken); err != nil {
+		return err
+	}
+	if token.AccessToken, err = s.encrypt(ctx, "access_token", token, token.AccessToken); err != nil {
+		return err
+	}
+	return s.store.Put(ctx, token)
+}
+
+// Get returns the token of subject and clientID decrypted
+func (s *EncryptedTokenStore) Get(ctx context.Context, subject, clientID string) (*StoredToken, error) {
+	token, err := s.store.Get(ctx, subject, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.decryptToken(ctx, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Delete removes the token of subject and clientID
+func (s *EncryptedTokenStore) Delete(ctx context.Context, subject, clientID string) error {
+	return s.store.Delete(ctx, subject, clientID)
+}
+
+// ListSubject returns the tokens of subject decrypted. Tokens that fail to decrypt are left out and
+// reported as TokenDecryptionErrors in the error, which is then returned along with the other tokens.
+func (s *EncryptedTokenStore) ListSubject(ctx context.Context, subject string) ([]StoredToken, error) {
+	tokens, err := s.store.ListSubject(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := tokens[:0]
+	var errs []error
+	for _, token := range tokens {
+		if err := s.decryptToken(ctx, &token); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		decrypted = append(decrypted, token)
+	}
+	return decrypted, errors.Join(errs...)
+}
+
+// Range calls fn with every token decrypted. Tokens that fail to decrypt are skipped, so one bad record
+// does not hide the others, and reported as TokenDecryptionErrors joined into the returned error.
+func (s *EncryptedTokenStore) Range(ctx context.Context, fn func(StoredToken) bool) error {
+	var errs []error
+	err := s.store.Range(ctx, func(token StoredToken) bool {
+		if err := s.decryptToken(ctx, &token); err != nil {
+			errs = append(errs, err)
+			return true
+		}
+		return fn(token)
+	})
+	return errors.Join(append(errs, err)...)
+}
+
+// encrypt seals plaintext, the secret of token in field, as "v1.<wrapped data key>.<nonce and ciphertext>".
+// Empty values stay empty.
+func (s *EncryptedTokenStore) encrypt(ctx context.Context, field string, token StoredToken, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealGCM(aead, []byte(plaintext), tokenAAD(field, token))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := s.kek.WrapKey(ctx, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("wrapping data key: %w", err)
+	}
+	return envelopePrefix + base64.RawURLEncoding.EncodeToString(wrapped) + "." + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// decryptToken decrypts the refresh and access tokens of token in place. Failures are returned as a
+// *TokenDecryptionError.
+func (s *EncryptedTokenStore) decryptToken(ctx context.Context, token *StoredToken) error {
+	refreshToken, err := s.decrypt(ctx, "refresh_token", *token, token.RefreshToken)
+	if err != nil {
+		return &TokenDecryptionError{Subject: token.Subject, ClientID: token.ClientID, Err: err}
+	}
+	accessToken, err := s.decrypt(ctx, "access_token", *token, token.AccessToken)
+	if err != nil {
+		return &TokenDecryptionError{Subject: token.Subject, ClientID: token.ClientID, Err: err}
+	}
+	token.RefreshToken, token.AccessToken = refreshToken, accessToken
+	return nil
+}
+
+// decrypt opens sealed, the secret of token in field sealed by encrypt
+func (s *EncryptedTokenStore) decrypt(ctx context.Context, field string, token StoredToken, sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, envelopePrefix), ".")
+	if !strings.HasPrefix(sealed, envelopePrefix) || len(parts) != 2 {
+		return "", errors.New("not an encrypted token")
+	}
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := s.kek.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("unwrapping data key: %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openGCM(aead, ciphertext, tokenAAD(field, token))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// tokenAAD binds a ciphertext to the record and field it belongs to
+func tokenAAD(field string, token StoredToken) []byte {
+	return []byte(field + "\x00" + token.Subject + "\x00" + token.ClientID)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealGCM encrypts plaintext under a random nonce and returns the nonce followed by the ciphertext
+func sealGCM(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openGCM decrypts the output of sealGCM
+func openGCM(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,141 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrTokenNotFound is returned by TokenStore.Get when no refresh token is stored for the user and client ID
+var ErrTokenNotFound = errors.New("refresh token not found")
+
+// StoredToken is a refresh token kept for a user of one client ID
+type StoredToken struct {
+	// Subject is the user's stable identifier, the sub claim of their id_token
+	Subject string
+
+	// ClientID is the client ID the refresh token was issued to
+	ClientID string
+
+	// RefreshToken is the refresh token itself
+	RefreshToken string
+
+	// AccessToken is the most recent access token, if kept. It is revoked with the refresh token on
+	// account deletion.
+	AccessToken string
+
+	// StoredAt is when the token was first stored
+	StoredAt time.Time
+
+	// ValidatedAt is when Apple last confirmed the token was valid. It is zero until the first validation.
+	ValidatedAt time.Time
+}
+
+// TokenStore keeps refresh tokens keyed by subject and client ID. Refresh tokens are long-lived
+// credentials: wrap persistent stores with NewEncryptedTokenStore.
+type TokenStore interface {
+	// Put stores token, replacing any token of the same subject and client ID
+	Put(ctx context.Context, token StoredToken) error
+
+	// Get returns the token of subject and clientID, or ErrTokenNotFound
+	Get(ctx context.Context, subject, clientID string) (*StoredToken, error)
+
+	// Delete removes the token of subject and clientID. Deleting a missing token is not an error.
+	Delete(ctx context.Context, subject, clientID string) error
+
+	// ListSubject returns every token stored for subject, across client IDs
+	ListSubject(ctx context.Context, subject string) ([]StoredToken, error)
+
+	// Range calls fn for every stored token until fn returns false. fn may modify the store.
+	Range(ctx context.Context, fn func(StoredToken) bool) error
+}
+
+type tokenKey struct {
+	subject  string
+	clientID string
+}
+
+// MemoryTokenStore is an in-memory TokenStore for tests and single-process tools. Tokens are lost on restart.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[tokenKey]StoredToken
+}
+
+// NewMemoryTokenStore creates an empty MemoryTokenStore
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[tokenKey]StoredToken)}
+}
+
+// Put stores token
+func (m *MemoryTokenStore) Put(ctx context.Context, token StoredToken) error {
+	if token.Subject == "" || token.ClientID == "" {
+		return errors.New("stored token needs a subject and a client ID")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[tokenKey{token.Subject, token.ClientID}] = token
+	return nil
+}
+
+// Get returns the token of subject and clientID
+func (m *MemoryTokenStore) Get(ctx context.Context, subject, clientID string) (*StoredToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.tokens[tokenKey{subject, clientID}]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return &token, nil
+}
+
+// Delete removes the token of subject and clientID
+func (m *MemoryTokenStore) Delete(ctx context.Context, subject, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tokens, tokenKey{subject, clientID})
+	return nil
+}
+
+// ListSubject returns the tokens of subject ordered by client ID
+func (m *MemoryTokenStore) ListSubject(ctx context.Context, subject string) ([]StoredToken, error) {
+	var tokens []StoredToken
+	for _, token := range m.snapshot() {
+		if token.Subject == subject {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// Range calls fn for every token, ordered by subject and client ID, on a snapshot of the store
+func (m *MemoryTokenStore) Range(ctx context.Context, fn func(StoredToken) bool) error {
+	for _, token := range m.snapshot() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(token) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// snapshot copies the stored tokens in a stable order
+func (m *MemoryTokenStore) snapshot() []StoredToken {
+	m.mu.Lock()
+	tokens := make([]StoredToken, 0, len(m.tokens))
+	for _, token := range m.tokens {
+		tokens = append(tokens, token)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Subject != tokens[j].Subject {
+			return tokens[i].Subject < tokens[j].Subject
+		}
+		return tokens[i].ClientID < tokens[j].ClientID
+	})
+	return tokens
+}
@@ -0,0 +1,115 @@
+package apple
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKEK(t *testing.T) *AESKeyEncryptionKey {
+	t.Helper()
+	kek, err := NewAESKeyEncryptionKey(bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+	return kek
+}
+
+func TestMemoryTokenStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTokenStore()
+	now := time.Unix(1700000000, 0)
+
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user1", ClientID: "com.example.web", RefreshToken: "r1", StoredAt: now}))
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user1", ClientID: "com.example.app", RefreshToken: "r2", StoredAt: now}))
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user2", ClientID: "com.example.app", RefreshToken: "r3", StoredAt: now}))
+	assert.Error(t, store.Put(ctx, StoredToken{ClientID: "com.example.app"}))
+
+	token, err := store.Get(ctx, "user1", "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, "r2", token.RefreshToken)
+
+	tokens, err := store.ListSubject(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, "com.example.app", tokens[0].ClientID)
+
+	var seen []string
+	require.NoError(t, store.Range(ctx, func(token StoredToken) bool {
+		seen = append(seen, token.RefreshToken)
+		return store.Delete(ctx, token.Subject, token.ClientID) == nil && len(seen) < 2
+	}))
+	assert.Equal(t, []string{"r2", "r1"}, seen)
+
+	_, err = store.Get(ctx, "user1", "com.example.app")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+	assert.NoError(t, store.Delete(ctx, "missing", "com.example.app"))
+}
+
+func TestEncryptedTokenStore(t *testing.T) {
+	ctx := context.Background()
+	backing := NewMemoryTokenStore()
+	store := NewEncryptedTokenStore(backing, newTestKEK(t))
+
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user1", ClientID: "com.example.app", RefreshToken: "secret-refresh-token", AccessToken: "secret-access-token"}))
+
+	raw, err := backing.Get(ctx, "user1", "com.example.app")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(raw.RefreshToken, envelopePrefix))
+	assert.NotContains(t, raw.RefreshToken, "secret-refresh-token")
+	assert.NotContains(t, raw.AccessToken, "secret-access-token")
+
+	token, err := store.Get(ctx, "user1", "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, "secret-refresh-token", token.RefreshToken)
+	assert.Equal(t, "secret-access-token", token.AccessToken)
+
+	tokens, err := store.ListSubject(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, "secret-refresh-token", tokens[0].RefreshToken)
+
+	// Ciphertexts swapped between the refresh and access token fields must not decrypt
+	swapped := *raw
+	swapped.RefreshToken, swapped.AccessToken = raw.AccessToken, raw.RefreshToken
+	require.NoError(t, backing.Put(ctx, swapped))
+	_, err = store.Get(ctx, "user1", "com.example.app")
+	assert.Error(t, err)
+	require.NoError(t, backing.Put(ctx, *raw))
+
+	// A ciphertext moved onto another user's record must not decrypt
+	raw.Subject = "user2"
+	require.NoError(t, backing.Put(ctx, *raw))
+	_, err = store.Get(ctx, "user2", "com.example.app")
+	assert.Error(t, err)
+	var visited []string
+	err = store.Range(ctx, func(token StoredToken) bool {
+		visited = append(visited, token.Subject)
+		return true
+	})
+	var decryptionErr *TokenDecryptionError
+	require.ErrorAs(t, err, &decryptionErr)
+	assert.Equal(t, "user2", decryptionErr.Subject)
+	assert.Equal(t, "com.example.app", decryptionErr.ClientID)
+	assert.Equal(t, []string{"user1"}, visited, "a record that fails to decrypt does not stop the iteration")
+
+	require.NoError(t, store.Put(ctx, StoredToken{Subject: "user2", ClientID: "com.example.web", RefreshToken: "other-refresh-token"}))
+	tokens, err = store.ListSubject(ctx, "user2")
+	require.ErrorAs(t, err, &decryptionErr)
+	assert.Equal(t, "com.example.app", decryptionErr.ClientID)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, "other-refresh-token", tokens[0].RefreshToken)
+
+	other, err := NewAESKeyEncryptionKey(bytes.Repeat([]byte{8}, 32))
+	require.NoError(t, err)
+	_, err = NewEncryptedTokenStore(backing, other).Get(ctx, "user1", "com.example.app")
+	assert.Error(t, err, "a different key-encryption key cannot unwrap the data key")
+}
+
+func TestNewAESKeyEncryptionKeyRejectsBadKeys(t *testing.T) {
+	_, err := NewAESKeyEncryptionKey([]byte("short"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,209 @@
+package apple
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Request is a request to one of Apple's token, revoke or user migration endpoints. It is implemented by
+// WebValidationTokenRequest, AppValidationTokenRequest, ValidationRefreshRequest, RevokeAccessTokenRequest,
+// RevokeRefreshTokenRequest and UserMigrationRequest, and cannot be implemented outside this package.
+type Request interface {
+	endpoint(cfg *clientConfig) string
+	credentials() (clientID, clientSecret string)
+	form(clientSecret string) url.Values
+	revocation() bool
+}
+
+func (r WebValidationTokenRequest) endpoint(cfg *clientConfig) string { return cfg.validationURL }
+func (r WebValidationTokenRequest) credentials() (string, string)     { return r.ClientID, r.ClientSecret }
+func (r WebValidationTokenRequest) revocation() bool                  { return false }
+func (r WebValidationTokenRequest) form(secret string) url.Values {
+	return url.Values{
+		"client_id":     {r.ClientID},
+		"client_secret": {secret},
+		"code":          {r.Code},
+		"redirect_uri":  {r.RedirectURI},
+		"grant_type":    {"authorization_code"},
+	}
+}
+
+func (r AppValidationTokenRequest) endpoint(cfg *clientConfig) string { return cfg.validationURL }
+func (r AppValidationTokenRequest) credentials() (string, string)     { return r.ClientID, r.ClientSecret }
+func (r AppValidationTokenRequest) revocation() bool                  { return false }
+func (r AppValidationTokenRequest) form(secret string) url.Values {
+	return url.Values{
+		"client_id":     {r.ClientID},
+		"client_secret": {secret},
+		"code":          {r.Code},
+		"grant_type":    {"authorization_code"},
+	}
+}
+
+func (r ValidationRefreshRequest) endpoint(cfg *clientConfig) string { return cfg.validationURL }
+func (r ValidationRefreshRequest) credentials() (string, string)     { return r.ClientID, r.ClientSecret }
+func (r ValidationRefreshRequest) revocation() bool                  { return false }
+func (r ValidationRefreshRequest) form(secret string) url.Values {
+	return url.Values{
+		"client_id":     {r.ClientID},
+		"client_secret": {secret},
+		"refresh_token": {r.RefreshToken},
+		"grant_type":    {"refresh_token"},
+	}
+}
+
+func (r RevokeRefreshTokenRequest) endpoint(cfg *clientConfig) string { return cfg.revokeURL }
+func (r RevokeRefreshTokenRequest) credentials() (string, string)     { return r.ClientID, r.ClientSecret }
+func (r RevokeRefreshTokenRequest) revocation() bool                  { return true }
+func (r RevokeRefreshTokenRequest) form(secret string) url.Values {
+	return url.Values{
+		"client_id":       {r.ClientID},
+		"client_secret":   {secret},
+		"token":           {r.RefreshToken},
+		"token_type_hint": {"refresh_token"},
+	}
+}
+
+func (r RevokeAccessTokenRequest) endpoint(cfg *clientConfig) string { return cfg.revokeURL }
+func (r RevokeAccessTokenRequest) credentials() (string, string)     { return r.ClientID, r.ClientSecret }
+func (r RevokeAccessTokenRequest) revocation() bool                  { return true }
+func (r RevokeAccessTokenRequest) form(secret string) url.Values {
+	return url.Values{
+		"client_id":       {r.ClientID},
+		"client_secret":   {secret},
+		"token":           {r.AccessToken},
+		"token_type_hint": {"access_token"},
+	}
+}
+
+func (r UserMigrationRequest) endpoint(cfg *clientConfig) string { return cfg.migrationURL }
+func (r UserMigrationRequest) credentials() (string, string)     { return r.ClientID, r.ClientSecret }
+func (r UserMigrationRequest) revocation() bool                  { return false }
+func (r UserMigrationRequest) form(secret string) url.Values {
+	return url.Values{
+		"client_id":     {r.ClientID},
+		"client_secret": {secret},
+		"transfer_sub":  {r.TransferSub},
+	}
+}
+
+// APIError is returned by Send and the TypedClient methods when Apple answers with an error field or a
+// non-2xx status. See https://developer.apple.com/documentation/sign_in_with_apple/errorresponse
+type APIError struct {
+	// StatusCode is the HTTP status of Apple's response
+	StatusCode int
+
+	// Code is Apple's error field, e.g. "invalid_grant". It is empty when the body was not an error response,
+	// as with a 503 from a proxy.
+	Code string
+
+	// Description is Apple's error_description field, or the start of the body when it was not JSON
+	Description string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("apple returned HTTP %d", e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// Temporary reports whether the request may succeed if retried: Apple was rate limiting (429) or
+// failing (5xx). Errors such as invalid_grant are permanent.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// maxErrorBody caps how much of a non-JSON error body is kept in APIError.Description
+const maxErrorBody = 512
+
+// Send posts req to its endpoint and decodes Apple's answer into a new T. T is usually ValidationResponse,
+// RefreshResponse, RevokeResponse or UserMigrationResponse, or a caller type embedding one of them to
+// capture additional fields.
+//
+// When Apple answers with an error, Send returns the decoded T together with an *APIError, so the
+// error fields remain available on T as well. A successful revoke has no body and yields a zero T.
+func Send[T any](ctx context.Context, c *Client, req Request) (*T, error) {
+	status, body, err := c.post(ctx, c.config.Load(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(T)
+	success := status >= 200 && status < 300
+	if success && req.revocation() && len(strings.TrimSpace(string(body))) == 0 {
+		return result, nil
+	}
+
+	var appleErr struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &appleErr); err != nil {
+		if success {
+			return nil, fmt.Errorf("decoding apple response: %w", err)
+		}
+		description := string(body)
+		if len(description) > maxErrorBody {
+			description = description[:maxErrorBody]
+		}
+		return nil, &APIError{StatusCode: status, Description: strings.TrimSpace(description)}
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("decoding apple response into %T: %w", result, err)
+	}
+
+	if !success || appleErr.Error != "" {
+		return result, &APIError{StatusCode: status, Code: appleErr.Error, Description: appleErr.ErrorDescription}
+	}
+	return result, nil
+}
+
+// TypedClient calls Apple's endpoints and returns typed responses. Apple errors are returned as *APIError
+// instead of being left in the response's Error field. Obtain one with Client.Typed.
+type TypedClient struct {
+	client *Client
+}
+
+// Typed returns the typed API of c. It shares c's configuration, including reloads.
+func (c *Client) Typed() *TypedClient {
+	return &TypedClient{client: c}
+}
+
+// VerifyWebToken exchanges an authorization code received by a web redirect
+func (t *TypedClient) VerifyWebToken(ctx context.Context, req WebValidationTokenRequest) (*ValidationResponse, error) {
+	return Send[ValidationResponse](ctx, t.client, req)
+}
+
+// VerifyAppToken exchanges an authorization code received by an app
+func (t *TypedClient) VerifyAppToken(ctx context.Context, req AppValidationTokenRequest) (*ValidationResponse, error) {
+	return Send[ValidationResponse](ctx, t.client, req)
+}
+
+// VerifyRefreshToken validates a refresh token and returns a new access token
+func (t *TypedClient) VerifyRefreshToken(ctx context.Context, req ValidationRefreshRequest) (*RefreshResponse, error) {
+	return Send[RefreshResponse](ctx, t.client, req)
+}
+
+// RevokeRefreshToken revokes a refresh token
+func (t *TypedClient) RevokeRefreshToken(ctx context.Context, req RevokeRefreshTokenRequest) (*RevokeResponse, error) {
+	return Send[RevokeResponse](ctx, t.client, req)
+}
+
+// RevokeAccessToken revokes an access token
+func (t *TypedClient) RevokeAccessToken(ctx context.Context, req RevokeAccessTokenRequest) (*RevokeResponse, error) {
+	return Send[RevokeResponse](ctx, t.client, req)
+}
+
+// GetUserMigrationInfo fetches the new user identifier for a user transferred from another team
+func (t *TypedClient) GetUserMigrationInfo(ctx context.Context, req UserMigrationRequest) (*UserMigrationResponse, error) {
+	return Send[UserMigrationResponse](ctx, t.client, req)
+}
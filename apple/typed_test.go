@@ -0,0 +1,95 @@
+package apple
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTypedTestServer(t *testing.T, status int, body string) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return NewWithOptions(ClientOptions{ValidationURL: srv.URL, RevokeURL: srv.URL, MigrationURL: srv.URL})
+}
+
+func TestTypedClientSuccess(t *testing.T) {
+	c := newTypedTestServer(t, http.StatusOK, `{"access_token":"a","token_type":"bearer","expires_in":3600,"refresh_token":"r","id_token":"i"}`)
+
+	resp, err := c.Typed().VerifyWebToken(context.Background(), WebValidationTokenRequest{ClientID: "cid", ClientSecret: "s", Code: "c"})
+	require.NoError(t, err)
+	assert.Equal(t, "r", resp.RefreshToken)
+	assert.Equal(t, "i", resp.IDToken)
+
+	refresh, err := c.Typed().VerifyRefreshToken(context.Background(), ValidationRefreshRequest{ClientID: "cid", ClientSecret: "s", RefreshToken: "r"})
+	require.NoError(t, err)
+	assert.Equal(t, 3600, refresh.ExpiresIn)
+}
+
+func TestTypedClientAPIError(t *testing.T) {
+	c := newTypedTestServer(t, http.StatusBadRequest, `{"error":"invalid_grant","error_description":"expired"}`)
+
+	resp, err := c.Typed().VerifyAppToken(context.Background(), AppValidationTokenRequest{ClientID: "cid", ClientSecret: "s", Code: "c"})
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "invalid_grant", apiErr.Code)
+	assert.Equal(t, "expired", apiErr.Description)
+	assert.False(t, apiErr.Temporary())
+	assert.Equal(t, "apple returned HTTP 400: invalid_grant: expired", apiErr.Error())
+	require.NotNil(t, resp, "the decoded response is returned alongside the error")
+	assert.Equal(t, "invalid_grant", resp.Error)
+}
+
+func TestTypedClientNonJSONError(t *testing.T) {
+	c := newTypedTestServer(t, http.StatusServiceUnavailable, `<html>Service Unavailable</html>`)
+
+	_, err := c.Typed().RevokeRefreshToken(context.Background(), RevokeRefreshTokenRequest{ClientID: "cid", ClientSecret: "s", RefreshToken: "r"})
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.True(t, apiErr.Temporary())
+	assert.Empty(t, apiErr.Code)
+	assert.Contains(t, apiErr.Description, "Service Unavailable")
+
+	c = newTypedTestServer(t, http.StatusTooManyRequests, ``)
+	_, err = c.Typed().GetUserMigrationInfo(context.Background(), UserMigrationRequest{ClientID: "cid", ClientSecret: "s", TransferSub: "t"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.True(t, apiErr.Temporary())
+}
+
+func TestTypedClientRevokeSuccess(t *testing.T) {
+	c := newTypedTestServer(t, http.StatusOK, ``)
+
+	resp, err := c.Typed().RevokeAccessToken(context.Background(), RevokeAccessTokenRequest{ClientID: "cid", ClientSecret: "s", AccessToken: "a"})
+	require.NoError(t, err)
+	assert.Equal(t, &RevokeResponse{}, resp)
+}
+
+func TestSendCustomType(t *testing.T) {
+	type extendedResponse struct {
+		ValidationResponse
+		Scope string `json:"scope"`
+	}
+	c := newTypedTestServer(t, http.StatusOK, `{"access_token":"a","scope":"name email"}`)
+
+	resp, err := Send[extendedResponse](context.Background(), c, AppValidationTokenRequest{ClientID: "cid", ClientSecret: "s", Code: "c"})
+	require.NoError(t, err)
+	assert.Equal(t, "a", resp.AccessToken)
+	assert.Equal(t, "name email", resp.Scope)
+}
+
+func TestUntypedMethodsRejectNonPointerResults(t *testing.T) {
+	c := newTypedTestServer(t, http.StatusOK, `{"access_token":"a"}`)
+
+	var resp ValidationResponse
+	err := c.VerifyAppToken(context.Background(), AppValidationTokenRequest{ClientID: "cid", ClientSecret: "s", Code: "c"}, resp)
+	assert.Error(t, err, "a struct passed by value used to decode nothing without an error")
+}
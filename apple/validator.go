@@ -5,10 +5,12 @@ import (
 	"crypto"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -31,34 +33,102 @@ const (
 	AcceptHeader string = "application/json"
 )
 
-// ValidationClient is an interface to call the validation API
-type ValidationClient interface {
+// TokenExchanger exchanges authorization codes and refresh tokens at Apple's token endpoint
+type TokenExchanger interface {
 	VerifyWebToken(ctx context.Context, reqBody WebValidationTokenRequest, result interface{}) error
 	VerifyAppToken(ctx context.Context, reqBody AppValidationTokenRequest, result interface{}) error
 	VerifyRefreshToken(ctx context.Context, reqBody ValidationRefreshRequest, result interface{}) error
+}
+
+// TokenRevoker revokes tokens at Apple's revoke endpoint
+type TokenRevoker interface {
 	RevokeAccessToken(ctx context.Context, reqBody RevokeAccessTokenRequest, result interface{}) error
 	RevokeRefreshToken(ctx context.Context, reqBody RevokeRefreshTokenRequest, result interface{}) error
 }
 
+// IDTokenVerifier verifies id_tokens against Apple's public keys
+type IDTokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken, clientID string) (*IDTokenClaims, error)
+}
+
+// NotificationParser verifies and decodes Apple's server-to-server notifications
+type NotificationParser interface {
+	ParseServerNotification(ctx context.Context, jwtPayload string) (*ServerNotificationClaims, error)
+}
+
+// UserMigrator looks up the identifiers of users transferred from another team
+type UserMigrator interface {
+	GetUserMigrationInfo(ctx context.Context, req UserMigrationRequest, resp *UserMigrationResponse) error
+}
+
+// SessionExchanger exchanges authorization codes for verified sessions, refreshes them and revokes a
+// user's tokens, building on the lower-level calls
+type SessionExchanger interface {
+	Exchange(ctx context.Context, req ExchangeRequest) (*Session, error)
+	Refresh(ctx context.Context, req RefreshRequest) (*Session, error)
+	RevokeUser(ctx context.Context, req RevokeUserRequest) *RevokeUserResult
+}
+
+// ValidationClient is an interface to call the validation API
+type ValidationClient interface {
+	TokenExchanger
+	TokenRevoker
+}
+
+// API is the whole Sign in with Apple API. It is implemented by Client and FakeClient; depend on the
+// narrower interfaces where only part of it is needed.
+type API interface {
+	TokenExchanger
+	TokenRevoker
+	IDTokenVerifier
+	NotificationParser
+	UserMigrator
+	SessionExchanger
+}
+
 // HTTPClient is an interface for an HTTP client to have a support
 // of an http.Client wrappers or replacements (e.g., some custom mocks).
 type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-// Client implements ValidationClient
+// Client implements API
 type Client struct {
+	config atomic.Pointer[clientConfig]
+
+	jwksMu        sync.RWMutex
+	jwksCache     map[string]crypto.PublicKey
+	jwksSet       *JWKS
+	jwksFetchedAt time.Time
+
+	jwksRefreshMu       sync.Mutex
+	jwksRefresh         *jwksRefreshCall
+	jwksRefreshErr      error
+	jwksRefreshFailedAt time.Time
+
+	reloadMu    sync.Mutex
+	reloadHooks []func(previous, current ClientOptions)
+
+	exchangesMu sync.Mutex
+	exchanges   map[string]*exchangeCall
+}
+
+// clientConfig is an immutable snapshot of a Client's resolved options. Each call loads it once,
+// so a Reload never affects calls that are already in flight.
+type clientConfig struct {
+	options ClientOptions
+
 	validationURL string
 	revokeURL     string
 	migrationURL  string
 	keysURL       string
+	issuer        string
 	skipVerify    bool
 	client        HTTPClient
-
-	jwksMu        sync.RWMutex
-	jwksCache     map[string]crypto.PublicKey
-	jwksFetchedAt time.Time
+	clock         Clock
 	jwksCacheTTL  time.Duration
+	secrets       SecretProvider
+	exchangeCache ExchangeCache
 }
 
 // ClientOptions is a struct to hold the options for the client
@@ -75,6 +145,9 @@ type ClientOptions struct {
 	// AppleKeysURL overrides the JWKS endpoint used to fetch Apple's public keys.
 	// Defaults to https://appleid.apple.com/auth/keys.
 	AppleKeysURL string
+	// Issuer overrides the expected iss claim of id_tokens and server notifications.
+	// Defaults to https://appleid.apple.com.
+	Issuer string
 	// JWKSCacheTTL controls how long Apple's public keys are cached before being
 	// re-fetched. Defaults to 15 minutes. Apple rotates keys infrequently; values
 	// between 5 and 60 minutes are reasonable for production.
@@ -82,9 +155,21 @@ type ClientOptions struct {
 	// SkipIDTokenVerification disables RS256 signature verification in VerifyIDToken
 	// and ParseServerNotification. For use in tests only.
 	SkipIDTokenVerification bool
+	// AllowInsecureDiscovery lets NewWithDiscovery and NewWithDiscoveryDocument accept discovery
+	// documents with http:// endpoints. For use in tests only.
+	AllowInsecureDiscovery bool
 	// Client overrides the HTTP client used for all outbound requests.
 	// Defaults to an http.Client with a 5-second timeout.
 	Client HTTPClient
+	// Clock overrides the time source used for JWKS cache expiry and token expiry checks.
+	// Defaults to SystemClock.
+	Clock Clock
+	// Secrets supplies the client secret for requests whose ClientSecret field is empty,
+	// so credentials can be managed, and reloaded, with the client rather than by every caller.
+	Secrets SecretProvider
+	// ExchangeCache, when set, makes authorization code exchanges idempotent: concurrent exchanges of
+	// the same code share one Apple call and successful responses are replayed for the code's lifetime.
+	ExchangeCache ExchangeCache
 }
 
 // New creates a Client object with the default URLs and a default http client
@@ -104,6 +189,15 @@ func NewWithURL(validationURL string, revokeURL string) *Client {
 
 // NewWithOptions creates a Client object with custom options. It will default to the standard options if not provided
 func NewWithOptions(options ClientOptions) *Client {
+	c := &Client{
+		jwksCache: make(map[string]crypto.PublicKey),
+	}
+	c.config.Store(newClientConfig(options))
+	return c
+}
+
+// newClientConfig applies the defaults to options and snapshots the result
+func newClientConfig(options ClientOptions) *clientConfig {
 	if options.Client == nil {
 		options.Client = &http.Client{
 			Timeout: 5 * time.Second,
@@ -121,93 +215,82 @@ func NewWithOptions(options ClientOptions) *Client {
 	if options.AppleKeysURL == "" {
 		options.AppleKeysURL = AppleKeysURL
 	}
+	if options.Issuer == "" {
+		options.Issuer = AppleIssuer
+	}
 	if options.JWKSCacheTTL == 0 {
 		options.JWKSCacheTTL = 15 * time.Minute
 	}
+	if options.Clock == nil {
+		options.Clock = SystemClock
+	}
 
-	return &Client{
+	return &clientConfig{
+		options:       options,
 		validationURL: options.ValidationURL,
 		revokeURL:     options.RevokeURL,
 		migrationURL:  options.MigrationURL,
 		keysURL:       options.AppleKeysURL,
+		issuer:        options.Issuer,
 		skipVerify:    options.SkipIDTokenVerification,
-		jwksCacheTTL:  options.JWKSCacheTTL,
-		jwksCache:     make(map[string]crypto.PublicKey),
 		client:        options.Client,
+		clock:         options.Clock,
+		jwksCacheTTL:  options.JWKSCacheTTL,
+		secrets:       options.Secrets,
+		exchangeCache: options.ExchangeCache,
 	}
 }
 
 // VerifyWebToken sends the WebValidationTokenRequest and gets validation result
 func (c *Client) VerifyWebToken(ctx context.Context, reqBody WebValidationTokenRequest, result interface{}) error {
-	data := url.Values{
-		"client_id":     {reqBody.ClientID},
-		"client_secret": {reqBody.ClientSecret},
-		"code":          {reqBody.Code},
-		"redirect_uri":  {reqBody.RedirectURI},
-		"grant_type":    {"authorization_code"},
-	}
-
-	return doValidationRequest(ctx, c.client, &result, c.validationURL, data)
+	return c.do(ctx, reqBody, result)
 }
 
 // VerifyAppToken sends the AppValidationTokenRequest and gets validation result
 func (c *Client) VerifyAppToken(ctx context.Context, reqBody AppValidationTokenRequest, result interface{}) error {
-	data := url.Values{
-		"client_id":     {reqBody.ClientID},
-		"client_secret": {reqBody.ClientSecret},
-		"code":          {reqBody.Code},
-		"grant_type":    {"authorization_code"},
-	}
-
-	return doValidationRequest(ctx, c.client, &result, c.validationURL, data)
+	return c.do(ctx, reqBody, result)
 }
 
 // VerifyRefreshToken sends the WebValidationTokenRequest and gets validation result
 func (c *Client) VerifyRefreshToken(ctx context.Context, reqBody ValidationRefreshRequest, result interface{}) error {
-	data := url.Values{
-		"client_id":     {reqBody.ClientID},
-		"client_secret": {reqBody.ClientSecret},
-		"refresh_token": {reqBody.RefreshToken},
-		"grant_type":    {"refresh_token"},
-	}
-
-	return doValidationRequest(ctx, c.client, &result, c.validationURL, data)
+	return c.do(ctx, reqBody, result)
 }
 
 // RevokeRefreshToken revokes the Refresh Token and gets the revoke result
 func (c *Client) RevokeRefreshToken(ctx context.Context, reqBody RevokeRefreshTokenRequest, result interface{}) error {
-	data := url.Values{
-		"client_id":       {reqBody.ClientID},
-		"client_secret":   {reqBody.ClientSecret},
-		"token":           {reqBody.RefreshToken},
-		"token_type_hint": {"refresh_token"},
-	}
-
-	return doRevokeRequest(ctx, c.client, &result, c.revokeURL, data)
+	return c.do(ctx, reqBody, result)
 }
 
 // RevokeAccessToken revokes the Access Token and gets the revoke result
 func (c *Client) RevokeAccessToken(ctx context.Context, reqBody RevokeAccessTokenRequest, result interface{}) error {
-	data := url.Values{
-		"client_id":       {reqBody.ClientID},
-		"client_secret":   {reqBody.ClientSecret},
-		"token":           {reqBody.AccessToken},
-		"token_type_hint": {"access_token"},
-	}
-
-	return doRevokeRequest(ctx, c.client, &result, c.revokeURL, data)
+	return c.do(ctx, reqBody, result)
 }
 
 // GetUserMigrationInfo fetches the new user identifier for a user migrating from another developer team.
 // See https://developer.apple.com/documentation/technotes/tn3159-migrating-sign-in-with-apple-users-for-an-app-transfer
 func (c *Client) GetUserMigrationInfo(ctx context.Context, req UserMigrationRequest, resp *UserMigrationResponse) error {
-	data := url.Values{
-		"client_id":     {req.ClientID},
-		"client_secret": {req.ClientSecret},
-		"transfer_sub":  {req.TransferSub},
+	return c.do(ctx, req, resp)
+}
+
+// do sends req and decodes Apple's answer into result, the behaviour of the untyped methods. A
+// successful revocation has no body and leaves result untouched.
+func (c *Client) do(ctx context.Context, req Request, result interface{}) error {
+	status, body, err := c.post(ctx, c.config.Load(), req)
+	if err != nil {
+		return err
 	}
+	if req.revocation() && status >= 200 && status < 300 {
+		return nil
+	}
+	return json.Unmarshal(body, result)
+}
 
-	return doValidationRequest(ctx, c.client, resp, c.migrationURL, data)
+// clientSecret returns given when set, and otherwise asks the configured SecretProvider for a secret
+func (cfg *clientConfig) clientSecret(ctx context.Context, clientID, given string) (string, error) {
+	if given != "" || cfg.secrets == nil {
+		return given, nil
+	}
+	return cfg.secrets.ClientSecret(ctx, clientID)
 }
 
 // GetTypedClaims decodes the id_token into a typed IDTokenClaims struct without verifying the signature.
@@ -255,52 +338,76 @@ func GetClaims(idToken string) (*jwt.MapClaims, error) {
 	return &claims, nil
 }
 
-// doValidationRequest handles validation requests that always decode JSON responses
-func doValidationRequest(ctx context.Context, client HTTPClient, result interface{}, url string, data url.Values) error {
+// postForm posts the form data to url with the headers Apple requires
+func postForm(ctx context.Context, client HTTPClient, url string, data url.Values) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(data.Encode()))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Add("content-type", ContentType)
 	req.Header.Add("accept", AcceptHeader)
 	req.Header.Add("user-agent", UserAgent) // apple requires a user agent
 
-	res, err := client.Do(req)
-	if err != nil {
-		return err
-	}
+	return client.Do(req)
+}
 
-	defer res.Body.Close()
-	return json.NewDecoder(res.Body).Decode(result)
+// post sends req and returns Apple's status and body. Authorization code exchanges go through the
+// ExchangeCache when one is configured.
+func (c *Client) post(ctx context.Context, cfg *clientConfig, req Request) (int, []byte, error) {
+	if cfg.exchangeCache != nil {
+		if key, ok := exchangeKey(req); ok {
+			return c.exchangeOnce(ctx, cfg, req, key)
+		}
+	}
+	return cfg.send(ctx, req)
 }
 
-// doRevokeRequest handles revoke requests that only succeed on 2xx status codes
-func doRevokeRequest(ctx context.Context, client HTTPClient, result interface{}, url string, data url.Values) error {
-	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(data.Encode()))
+// send posts req to its endpoint and reads Apple's answer. When Apple rejects a secret supplied by
+// ClientOptions.Secrets with invalid_client and the provider implements SecretFailover, the request is
+// retried once with the secret the provider hands out next.
+func (cfg *clientConfig) send(ctx context.Context, req Request) (int, []byte, error) {
+	clientID, given := req.credentials()
+	secret, err := cfg.clientSecret(ctx, clientID, given)
 	if err != nil {
-		return err
+		return 0, nil, err
+	}
+	status, body, err := cfg.postForm(ctx, req.endpoint(cfg), req.form(secret))
+	if err != nil || given != "" || !invalidClient(body) {
+		return status, body, err
 	}
 
-	req.Header.Add("content-type", ContentType)
-	req.Header.Add("accept", AcceptHeader)
-	req.Header.Add("user-agent", UserAgent) // apple requires a user agent
+	failover, ok := cfg.secrets.(SecretFailover)
+	if !ok || !failover.SecretRejected(ctx, clientID, secret) {
+		return status, body, nil
+	}
+	if secret, err = cfg.secrets.ClientSecret(ctx, clientID); err != nil {
+		return 0, nil, err
+	}
+	return cfg.postForm(ctx, req.endpoint(cfg), req.form(secret))
+}
 
-	res, err := client.Do(req)
+// postForm posts data to endpoint and reads Apple's answer
+func (cfg *clientConfig) postForm(ctx context.Context, endpoint string, data url.Values) (int, []byte, error) {
+	res, err := postForm(ctx, cfg.client, endpoint, data)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
+	defer res.Body.Close()
 
-	if res.Body != nil {
-		defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
 	}
+	return res.StatusCode, body, nil
+}
 
-	// We only need to decode the result if there was an error. A successful revoke is a 200 without a body
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return json.NewDecoder(res.Body).Decode(result)
+// invalidClient reports whether body is Apple's invalid_client error, its answer to a rejected client secret
+func invalidClient(body []byte) bool {
+	var resp struct {
+		Error string `json:"error"`
 	}
-
-	return nil
+	return json.Unmarshal(body, &resp) == nil && resp.Error == "invalid_client"
 }
 
 // idTokenClaimsFromMap converts jwt.MapClaims into a typed IDTokenClaims.
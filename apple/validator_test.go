@@ -19,9 +19,9 @@ func TestNew(t *testing.T) {
 	c := New()
 
 	assert.IsType(t, &Client{}, c, "expected New to return a Client type")
-	assert.Equal(t, ValidationURL, c.validationURL, "expected the client's validation url to be %s, but got %s", ValidationURL, c.validationURL)
-	assert.Equal(t, RevokeURL, c.revokeURL, "expected the client's revoke url to be %s, but got %s", RevokeURL, c.revokeURL)
-	assert.NotNil(t, c.client, "the client's http client should not be empty")
+	assert.Equal(t, ValidationURL, c.config.Load().validationURL, "expected the client's validation url to be %s, but got %s", ValidationURL, c.config.Load().validationURL)
+	assert.Equal(t, RevokeURL, c.config.Load().revokeURL, "expected the client's revoke url to be %s, but got %s", RevokeURL, c.config.Load().revokeURL)
+	assert.NotNil(t, c.config.Load().client, "the client's http client should not be empty")
 }
 
 func TestNewWithURL(t *testing.T) {
@@ -31,9 +31,9 @@ func TestNewWithURL(t *testing.T) {
 	})
 
 	assert.IsType(t, &Client{}, c, "expected New to return a Client type")
-	assert.Equal(t, "validationURL", c.validationURL, "expected the client's validation url to be %s, but got %s", "validationURL", c.validationURL)
-	assert.Equal(t, "revokeURL", c.revokeURL, "expected the client's revoke url to be %s, but got %s", "revokeURL", c.revokeURL)
-	assert.NotNil(t, c.client, "the client's http client should not be empty")
+	assert.Equal(t, "validationURL", c.config.Load().validationURL, "expected the client's validation url to be %s, but got %s", "validationURL", c.config.Load().validationURL)
+	assert.Equal(t, "revokeURL", c.config.Load().revokeURL, "expected the client's revoke url to be %s, but got %s", "revokeURL", c.config.Load().revokeURL)
+	assert.NotNil(t, c.config.Load().client, "the client's http client should not be empty")
 }
 
 func TestNewWithOptions(t *testing.T) {
@@ -100,11 +100,11 @@ func TestNewWithOptions(t *testing.T) {
 			c := NewWithOptions(tt.opts)
 
 			assert.IsType(t, &Client{}, c, "expected New to return a Client type")
-			assert.Equal(t, tt.expectedValidationURL, c.validationURL, "expected the client's validation url to be %s, but got %s", tt.expectedValidationURL, c.validationURL)
-			assert.Equal(t, tt.expectedRevokeURL, c.revokeURL, "expected the client's revoke url to be %s, but got %s", tt.expectedRevokeURL, c.revokeURL)
-			assert.NotNil(t, c.client, "the client's http client should not be empty")
+			assert.Equal(t, tt.expectedValidationURL, c.config.Load().validationURL, "expected the client's validation url to be %s, but got %s", tt.expectedValidationURL, c.config.Load().validationURL)
+			assert.Equal(t, tt.expectedRevokeURL, c.config.Load().revokeURL, "expected the client's revoke url to be %s, but got %s", tt.expectedRevokeURL, c.config.Load().revokeURL)
+			assert.NotNil(t, c.config.Load().client, "the client's http client should not be empty")
 
-			httpClient, ok := c.client.(*http.Client)
+			httpClient, ok := c.config.Load().client.(*http.Client)
 			require.True(t, ok, "the client's http client should be of type *http.Client")
 			assert.Equal(t, tt.expectedClientTimeout, httpClient.Timeout, "expected the client's timeout to be %s, but got %s", tt.expectedClientTimeout, httpClient.Timeout)
 		})
@@ -201,7 +201,7 @@ func TestDoRequestSuccess(t *testing.T) {
 		ValidationURL: srv.URL,
 		RevokeURL:     "revokeUrl",
 	})
-	assert.NoError(t, doValidationRequest(context.Background(), c.client, &actual, c.validationURL, url.Values{}))
+	assert.NoError(t, c.do(context.Background(), WebValidationTokenRequest{}, &actual))
 	assert.Equal(t, "123", actual.IDToken)
 }
 
@@ -211,7 +211,7 @@ func TestDoRequestBadServer(t *testing.T) {
 		ValidationURL: "foo.test",
 		RevokeURL:     "revokeUrl",
 	})
-	assert.Error(t, doValidationRequest(context.Background(), c.client, &actual, c.validationURL, url.Values{}))
+	assert.Error(t, c.do(context.Background(), WebValidationTokenRequest{}, &actual))
 }
 
 func TestDoRequestNewRequestFail(t *testing.T) {
@@ -220,7 +220,7 @@ func TestDoRequestNewRequestFail(t *testing.T) {
 		ValidationURL: "http://fo  o.test",
 		RevokeURL:     "revokeUrl",
 	})
-	assert.Error(t, doValidationRequest(context.Background(), c.client, &actual, c.validationURL, nil))
+	assert.Error(t, c.do(context.Background(), WebValidationTokenRequest{}, &actual))
 }
 
 func TestVerifyAppToken(t *testing.T) {
@@ -938,5 +938,5 @@ func TestGetUserMigrationInfo(t *testing.T) {
 
 func TestNewWithOptionsDefaults(t *testing.T) {
 	c := New()
-	assert.Equal(t, MigrationURL, c.migrationURL)
+	assert.Equal(t, MigrationURL, c.config.Load().migrationURL)
 }
@@ -3,13 +3,10 @@ package apple
 import (
 	"context"
 	"crypto"
-	"crypto/rsa"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/big"
 	"net/http"
-	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -19,19 +16,6 @@ const (
 	AppleKeysURL = "https://appleid.apple.com/auth/keys"
 )
 
-type jwksResponse struct {
-	Keys []jwkKey `json:"keys"`
-}
-
-type jwkKey struct {
-	Kty string `json:"kty"`
-	Kid string `json:"kid"`
-	Use string `json:"use"`
-	Alg string `json:"alg"`
-	N   string `json:"n"`
-	E   string `json:"e"`
-}
-
 // VerifyIDToken fetches Apple's public JWKS (with caching), verifies the RS256 signature
 // on the id_token, validates the issuer, audience, and expiration, and returns typed claims.
 //
@@ -41,7 +25,8 @@ type jwkKey struct {
 // When ClientOptions.SkipIDTokenVerification is true, signature verification is skipped and
 // claims are decoded without validation. For use in tests only.
 func (c *Client) VerifyIDToken(ctx context.Context, idToken, clientID string) (*IDTokenClaims, error) {
-	if c.skipVerify {
+	cfg := c.config.Load()
+	if cfg.skipVerify {
 		return GetTypedClaims(idToken)
 	}
 
@@ -53,11 +38,12 @@ func (c *Client) VerifyIDToken(ctx context.Context, idToken, clientID string) (*
 		if !ok {
 			return nil, fmt.Errorf("missing kid in token header")
 		}
-		return c.getPublicKey(ctx, kid)
+		return c.getPublicKey(ctx, cfg, kid)
 	},
-		jwt.WithIssuer(AppleIssuer),
+		jwt.WithIssuer(cfg.issuer),
 		jwt.WithAudience(clientID),
 		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(cfg.clock.Now),
 	)
 	if err != nil {
 		return nil, err
@@ -71,86 +57,80 @@ func (c *Client) VerifyIDToken(ctx context.Context, idToken, clientID string) (*
 	return idTokenClaimsFromMap(m), nil
 }
 
-// getPublicKey returns the RSA public key for the given kid.
+// getPublicKey returns the public key for the given kid.
 // It uses the in-memory JWKS cache, refreshing when the cache is stale or the kid is unknown.
-func (c *Client) getPublicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+func (c *Client) getPublicKey(ctx context.Context, cfg *clientConfig, kid string) (crypto.PublicKey, error) {
 	c.jwksMu.RLock()
 	key, found := c.jwksCache[kid]
-	stale := time.Since(c.jwksFetchedAt) > c.jwksCacheTTL
+	stale := cfg.clock.Now().Sub(c.jwksFetchedAt) > cfg.jwksCacheTTL
 	c.jwksMu.RUnlock()
 
 	if found && !stale {
 		return key, nil
 	}
 
-	// Cache is stale or kid not found — refresh from Apple
-	if err := c.refreshJWKS(ctx); err != nil {
+	// Cache is stale or kid not found — refresh from Apple. The kid is looked up in the keys fetched
+	// here rather than in the shared cache, which a concurrent Reload may have cleared or not let us fill.
+	keys, err := c.refreshJWKS(ctx, cfg)
+	if err != nil {
 		return nil, err
 	}
 
-	c.jwksMu.RLock()
-	key, found = c.jwksCache[kid]
-	c.jwksMu.RUnlock()
-
+	key, found = keys[kid]
 	if !found {
 		return nil, fmt.Errorf("public key with kid %q not found in Apple JWKS", kid)
 	}
 	return key, nil
 }
 
-// refreshJWKS fetches the current key set from Apple and replaces the in-memory cache.
-func (c *Client) refreshJWKS(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", c.keysURL, nil)
+// refreshJWKS fetches the current key set from cfg's endpoint, replaces the in-memory cache with it and
+// returns the keys by kid.
+func (c *Client) refreshJWKS(ctx context.Context, cfg *clientConfig) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.keysURL, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Add("user-agent", UserAgent)
 
-	res, err := c.client.Do(req)
+	res, err := cfg.client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("Apple JWKS endpoint returned HTTP %d", res.StatusCode)
+		return nil, fmt.Errorf("Apple JWKS endpoint returned HTTP %d", res.StatusCode)
 	}
 
-	var jwks jwksResponse
+	var jwks JWKS
 	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
-		return fmt.Errorf("failed to decode Apple JWKS: %w", err)
+		return nil, fmt.Errorf("failed to decode Apple JWKS: %w", err)
 	}
 
-	newCache := make(map[string]crypto.PublicKey, len(jwks.Keys))
-	for _, key := range jwks.Keys {
-		if key.Kty != "RSA" {
+	signingKeys := jwks.SigningKeys()
+	newCache := make(map[string]crypto.PublicKey, len(signingKeys.Keys))
+	for _, key := range signingKeys.Keys {
+		pubKey, err := key.PublicKey()
+		if errors.Is(err, ErrUnsupportedKeyType) {
 			continue
 		}
-		pubKey, err := jwkToRSAPublicKey(key)
 		if err != nil {
-			return fmt.Errorf("invalid JWK with kid %q: %w", key.Kid, err)
+			return nil, fmt.Errorf("invalid JWK with kid %q: %w", key.Kid, err)
 		}
 		newCache[key.Kid] = pubKey
 	}
 
 	c.jwksMu.Lock()
-	c.jwksCache = newCache
-	c.jwksFetchedAt = time.Now()
-	c.jwksMu.Unlock()
+	defer c.jwksMu.Unlock()
 
-	return nil
-}
-
-func jwkToRSAPublicKey(key jwkKey) (*rsa.PublicKey, error) {
-	nBytes, err := base64.RawURLEncoding.DecodeString(key.N)
-	if err != nil {
-		return nil, fmt.Errorf("invalid modulus: %w", err)
+	// A Reload that changed the JWKS endpoint while this fetch was in flight wins the shared cache. The
+	// keys fetched here belong to the previous configuration, which the caller still finishes on.
+	if c.config.Load().keysURL != cfg.keysURL {
+		return newCache, nil
 	}
-	eBytes, err := base64.RawURLEncoding.DecodeString(key.E)
-	if err != nil {
-		return nil, fmt.Errorf("invalid exponent: %w", err)
-	}
-	n := new(big.Int).SetBytes(nBytes)
-	e := int(new(big.Int).SetBytes(eBytes).Int64())
-	return &rsa.PublicKey{N: n, E: e}, nil
+	c.jwksCache = newCache
+	c.jwksSet = &jwks
+	c.jwksFetchedAt = cfg.clock.Now()
+
+	return newCache, nil
 }
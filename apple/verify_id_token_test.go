@@ -2,6 +2,8 @@ package apple
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
@@ -303,3 +305,103 @@ func TestGetTypedClaimsInvalidToken(t *testing.T) {
 	_, err := GetTypedClaims("not.a.token")
 	assert.Error(t, err)
 }
+
+func TestRefreshJWKSHandlesMixedKeySets(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaJWK, err := NewJWK(&rsaKey.PublicKey, "rsa-sig", "")
+	require.NoError(t, err)
+	ecJWK, err := NewJWK(&ecKey.PublicKey, "ec-sig", "")
+	require.NoError(t, err)
+	encJWK, err := NewJWK(&rsaKey.PublicKey, "rsa-enc", "RSA-OAEP")
+	require.NoError(t, err)
+	encJWK.Use = "enc"
+
+	pssJWK, err := NewJWK(&rsaKey.PublicKey, "rsa-pss", "PS256")
+	require.NoError(t, err)
+	p384JWK := JWK{Kty: "EC", Kid: "p384", Use: "sig", Alg: "ES384", Crv: "P-384", X: "AA", Y: "AA"}
+
+	body, err := (&JWKS{Keys: []JWK{rsaJWK, ecJWK, encJWK, pssJWK, p384JWK, {Kty: "OKP", Kid: "ed"}}}).Marshal()
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	c := NewWithOptions(ClientOptions{AppleKeysURL: srv.URL})
+	keys, err := c.refreshJWKS(context.Background(), c.config.Load())
+	require.NoError(t, err)
+	assert.Equal(t, c.jwksCache, keys)
+
+	assert.Contains(t, c.jwksCache, "rsa-sig")
+	assert.Contains(t, c.jwksCache, "ec-sig", "EC P-256 keys should be converted")
+	assert.NotContains(t, c.jwksCache, "rsa-enc", "keys not intended for signatures should be skipped")
+	assert.NotContains(t, c.jwksCache, "ed", "unsupported key types should be skipped")
+	assert.NotContains(t, c.jwksCache, "rsa-pss", "unsupported algs should be skipped")
+	assert.NotContains(t, c.jwksCache, "p384", "unsupported curves should be skipped")
+}
+
+func TestRefreshJWKSRejectsMalformedKeys(t *testing.T) {
+	body := []byte(`{"keys":[{"kty":"RSA","kid":"bad","use":"sig","alg":"RS256","n":"!!!","e":"AQAB"}]}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	c := NewWithOptions(ClientOptions{AppleKeysURL: srv.URL})
+	_, err := c.refreshJWKS(context.Background(), c.config.Load())
+	assert.ErrorContains(t, err, `invalid JWK with kid "bad"`)
+}
+
+func TestVerifyIDTokenWithFakeClock(t *testing.T) {
+	privKey, jwksHandler := generateTestKey(t)
+
+	var callCount atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callCount.Add(1)
+		jwksHandler(w, r)
+	}))
+	defer srv.Close()
+
+	// A token issued years ago is valid as long as the injected clock says so
+	issuedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(issuedAt)
+	c := NewWithOptions(ClientOptions{
+		AppleKeysURL: srv.URL,
+		JWKSCacheTTL: 15 * time.Minute,
+		Clock:        clock,
+	})
+
+	token := makeIDToken(t, privKey, jwt.MapClaims{
+		"iss": AppleIssuer,
+		"aud": "com.example.app",
+		"sub": "u1",
+		"iat": float64(issuedAt.Unix()),
+		"exp": float64(issuedAt.Add(time.Hour).Unix()),
+	})
+
+	_, err := c.VerifyIDToken(context.Background(), token, "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), callCount.Load())
+
+	// Within the TTL the cache is reused
+	clock.Advance(14 * time.Minute)
+	_, err = c.VerifyIDToken(context.Background(), token, "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), callCount.Load())
+
+	// Past the TTL the key set is refetched without any real sleep
+	clock.Advance(2 * time.Minute)
+	_, err = c.VerifyIDToken(context.Background(), token, "com.example.app")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), callCount.Load())
+
+	// Past exp the token is rejected
+	clock.Advance(time.Hour)
+	_, err = c.VerifyIDToken(context.Background(), token, "com.example.app")
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+}
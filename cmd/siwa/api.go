@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Timothylock/go-signin-with-apple/apple"
+)
+
+// appleErrorExitCodes maps the error field of Apple's responses to exit codes so scripts can react to
+// the cause of a failure. Errors not listed exit with exitAppleError.
+var appleErrorExitCodes = map[string]int{
+	"invalid_request":        10,
+	"invalid_client":         11,
+	"invalid_grant":          12,
+	"unauthorized_client":    13,
+	"unsupported_grant_type": 14,
+	"invalid_scope":          15,
+}
+
+const exitAppleError = 19
+
+// apiFlags are the flags shared by the commands that call Apple
+type apiFlags struct {
+	creds         *credentials
+	validationURL string
+	revokeURL     string
+	migrationURL  string
+	timeout       time.Duration
+	showSecrets   bool
+}
+
+func (c *cli) apiFlagSet(name, arguments string) (*flag.FlagSet, *apiFlags) {
+	fs := c.flagSet(name, arguments)
+	f := &apiFlags{creds: credentialFlags(fs, true)}
+	fs.StringVar(&f.validationURL, "token-url", "", "token endpoint (env "+envPrefix+apple.EnvValidationURL+", default "+apple.ValidationURL+")")
+	fs.StringVar(&f.revokeURL, "revoke-url", "", "revoke endpoint (env "+envPrefix+apple.EnvRevokeURL+", default "+apple.RevokeURL+")")
+	fs.StringVar(&f.migrationURL, "migration-url", "", "user migration endpoint (env "+envPrefix+apple.EnvMigrationURL+", default "+apple.MigrationURL+")")
+	fs.DurationVar(&f.timeout, "timeout", 10*time.Second, "timeout of the request to Apple")
+	fs.BoolVar(&f.showSecrets, "show-secrets", false, "print tokens in full instead of redacting them")
+	return fs, f
+}
+
+// setup parses args, reads the credentials and returns the client, the client secret and the single token argument.
+// ok is false when the command should exit with code.
+func (c *cli) setup(fs *flag.FlagSet, f *apiFlags, args []string) (client *apple.Client, secret, arg string, code int, ok bool) {
+	if code, ok := c.parse(fs, args); !ok {
+		return nil, "", "", code, false
+	}
+	arg, err := c.tokenArg(fs)
+	if err != nil {
+		return nil, "", "", c.usageError(fs, "%v", err), false
+	}
+
+	f.creds.fromEnv(true)
+	if missing := f.creds.missing(f.creds.clientSecret == ""); len(missing) > 0 {
+		return nil, "", "", c.usageError(fs, "%s required, or -client-id and -client-secret", strings.Join(missing, ", ")), false
+	}
+	secret, err = f.creds.secret(c)
+	if err != nil {
+		return nil, "", "", c.fail(fs.Name(), err), false
+	}
+
+	for _, endpoint := range []struct {
+		field *string
+		env   string
+	}{
+		{&f.validationURL, apple.EnvValidationURL},
+		{&f.revokeURL, apple.EnvRevokeURL},
+		{&f.migrationURL, apple.EnvMigrationURL},
+	} {
+		if *endpoint.field == "" {
+			*endpoint.field, _ = lookupEnv(envPrefix + endpoint.env)
+		}
+	}
+	client = apple.NewWithOptions(apple.ClientOptions{
+		ValidationURL: f.validationURL,
+		RevokeURL:     f.revokeURL,
+		MigrationURL:  f.migrationURL,
+		Client:        &http.Client{Timeout: f.timeout},
+	})
+	return client, secret, arg, exitOK, true
+}
+
+func (f *apiFlags) redact(token string) string {
+	if f.showSecrets {
+		return token
+	}
+	return redact(token)
+}
+
+// appleResult reports Apple's error, if any, and returns the exit code for it
+func (c *cli) appleResult(name, appleError, description string) int {
+	if appleError == "" {
+		return exitOK
+	}
+	if description != "" {
+		fmt.Fprintf(c.stderr, "siwa %s: apple returned %s: %s\n", name, appleError, description)
+	} else {
+		fmt.Fprintf(c.stderr, "siwa %s: apple returned %s\n", name, appleError)
+	}
+	if code, ok := appleErrorExitCodes[appleError]; ok {
+		return code
+	}
+	return exitAppleError
+}
+
+// tokenOutput is the JSON output of exchange and refresh
+type tokenOutput struct {
+	TokenType        string               `json:"token_type,omitempty"`
+	ExpiresIn        int                  `json:"expires_in,omitempty"`
+	AccessToken      string               `json:"access_token,omitempty"`
+	RefreshToken     string               `json:"refresh_token,omitempty"`
+	IDToken          string               `json:"id_token,omitempty"`
+	Claims           *apple.IDTokenClaims `json:"claims,omitempty"`
+	Error            string               `json:"error,omitempty"`
+	ErrorDescription string               `json:"error_description,omitempty"`
+}
+
+func (c *cli) printTokens(name string, f *apiFlags, out tokenOutput) int {
+	out.AccessToken = f.redact(out.AccessToken)
+	out.RefreshToken = f.redact(out.RefreshToken)
+	out.IDToken = f.redact(out.IDToken)
+
+	if c.json {
+		if err := c.writeJSON(out); err != nil {
+			return c.fail(name, err)
+		}
+		return c.appleResult(name, out.Error, out.ErrorDescription)
+	}
+	if out.Error != "" {
+		return c.appleResult(name, out.Error, out.ErrorDescription)
+	}
+
+	rows := [][2]string{
+		{"token_type", out.TokenType},
+		{"expires_in", fmt.Sprintf("%ds (%s)", out.ExpiresIn, c.humanTime(c.now().Add(time.Duration(out.ExpiresIn)*time.Second)))},
+		{"access_token", out.AccessToken},
+	}
+	if out.RefreshToken != "" {
+		rows = append(rows, [2]string{"refresh_token", out.RefreshToken})
+	}
+	if out.IDToken != "" {
+		rows = append(rows, [2]string{"id_token", out.IDToken})
+	}
+	if out.Claims != nil {
+		rows = append(rows, [2]string{"sub", out.Claims.Subject})
+		if out.Claims.Email != "" {
+			rows = append(rows, [2]string{"email", out.Claims.Email})
+		}
+	}
+	c.table(rows)
+	return exitOK
+}
+
+func runExchange(c *cli, args []string) int {
+	fs, f := c.apiFlagSet("exchange", "<authorization_code | ->")
+	redirectURI := fs.String("redirect-uri", "", "redirect URI the code was sent to; set for web sign-in, omit for codes from an app")
+	client, secret, code, exit, ok := c.setup(fs, f, args)
+	if !ok {
+		return exit
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	var resp apple.ValidationResponse
+	var err error
+	if *redirectURI != "" {
+		err = client.VerifyWebToken(ctx, apple.WebValidationTokenRequest{
+			ClientID:     f.creds.clientID,
+			ClientSecret: secret,
+			Code:         code,
+			RedirectURI:  *redirectURI,
+		}, &resp)
+	} else {
+		err = client.VerifyAppToken(ctx, apple.AppValidationTokenRequest{
+			ClientID:     f.creds.clientID,
+			ClientSecret: secret,
+			Code:         code,
+		}, &resp)
+	}
+	if err != nil {
+		return c.fail(fs.Name(), err)
+	}
+
+	out := tokenOutput{
+		TokenType:        resp.TokenType,
+		ExpiresIn:        resp.ExpiresIn,
+		AccessToken:      resp.AccessToken,
+		RefreshToken:     resp.RefreshToken,
+		IDToken:          resp.IDToken,
+		Error:            resp.Error,
+		ErrorDescription: resp.ErrorDescription,
+	}
+	if resp.IDToken != "" {
+		// Apple returned the token directly over TLS, so its claims can be read without verifying the signature
+		out.Claims, _ = apple.GetTypedClaims(resp.IDToken)
+	}
+	return c.printTokens(fs.Name(), f, out)
+}
+
+func runRefresh(c *cli, args []string) int {
+	fs, f := c.apiFlagSet("refresh", "<refresh_token | ->")
+	client, secret, refreshToken, exit, ok := c.setup(fs, f, args)
+	if !ok {
+		return exit
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	var resp apple.RefreshResponse
+	if err := client.VerifyRefreshToken(ctx, apple.ValidationRefreshRequest{
+		ClientID:     f.creds.clientID,
+		ClientSecret: secret,
+		RefreshToken: refreshToken,
+	}, &resp); err != nil {
+		return c.fail(fs.Name(), err)
+	}
+
+	out := tokenOutput{
+		TokenType:        resp.TokenType,
+		ExpiresIn:        resp.ExpiresIn,
+		AccessToken:      resp.AccessToken,
+		IDToken:          resp.IDToken,
+		Error:            resp.Error,
+		ErrorDescription: resp.ErrorDescription,
+	}
+	if resp.IDToken != "" {
+		out.Claims, _ = apple.GetTypedClaims(resp.IDToken)
+	}
+	return c.printTokens(fs.Name(), f, out)
+}
+
+func runRevoke(c *cli, args []string) int {
+	fs, f := c.apiFlagSet("revoke", "<token | ->")
+	tokenType := fs.String("type", "refresh_token", "type of the token: refresh_token or access_token")
+	client, secret, token, exit, ok := c.setup(fs, f, args)
+	if !ok {
+		return exit
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	var resp apple.RevokeResponse
+	var err error
+	switch *tokenType {
+	case "refresh_token", "refresh":
+		err = client.RevokeRefreshToken(ctx, apple.RevokeRefreshTokenRequest{
+			ClientID:     f.creds.clientID,
+			ClientSecret: secret,
+			RefreshToken: token,
+		}, &resp)
+	case "access_token", "access":
+		err = client.RevokeAccessToken(ctx, apple.RevokeAccessTokenRequest{
+			ClientID:     f.creds.clientID,
+			ClientSecret: secret,
+			AccessToken:  token,
+		}, &resp)
+	default:
+		return c.usageError(fs, "-type must be refresh_token or access_token, got %q", *tokenType)
+	}
+	if err != nil {
+		return c.fail(fs.Name(), err)
+	}
+
+	if c.json {
+		if err := c.writeJSON(struct {
+			Revoked          bool   `json:"revoked"`
+			Token            string `json:"token"`
+			Error            string `json:"error,omitempty"`
+			ErrorDescription string `json:"error_description,omitempty"`
+		}{resp.Error == "", f.redact(token), resp.Error, resp.ErrorDescription}); err != nil {
+			return c.fail(fs.Name(), err)
+		}
+	} else if resp.Error == "" {
+		fmt.Fprintf(c.stdout, "revoked %s %s\n", strings.TrimSuffix(*tokenType, "_token"), f.redact(token))
+	}
+	return c.appleResult(fs.Name(), resp.Error, resp.ErrorDescription)
+}
+
+func runMigrate(c *cli, args []string) int {
+	fs, f := c.apiFlagSet("migrate", "<transfer_sub | ->")
+	client, secret, transferSub, exit, ok := c.setup(fs, f, args)
+	if !ok {
+		return exit
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	var resp apple.UserMigrationResponse
+	if err := client.GetUserMigrationInfo(ctx, apple.UserMigrationRequest{
+		ClientID:     f.creds.clientID,
+		ClientSecret: secret,
+		TransferSub:  transferSub,
+	}, &resp); err != nil {
+		return c.fail(fs.Name(), err)
+	}
+
+	if c.json {
+		if err := c.writeJSON(resp); err != nil {
+			return c.fail(fs.Name(), err)
+		}
+	} else if resp.Error == "" {
+		rows := [][2]string{{"sub", resp.Sub}}
+		if resp.Email != "" {
+			rows = append(rows, [2]string{"email", resp.Email}, [2]string{"email_verified", fmt.Sprint(resp.EmailVerified)})
+		}
+		c.table(rows)
+	}
+	return c.appleResult(fs.Name(), resp.Error, resp.ErrorDescription)
+}
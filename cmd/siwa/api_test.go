@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Timothylock/go-signin-with-apple/apple"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeApple answers token, revoke and migration requests with the responses set on it and records the forms it received
+type fakeApple struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	forms     map[string]url.Values
+	responses map[string]string
+	status    map[string]int
+}
+
+func newFakeApple(t *testing.T) *fakeApple {
+	f := &fakeApple{forms: map[string]url.Values{}, responses: map[string]string{}, status: map[string]int{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.forms[r.URL.Path] = r.PostForm
+		if status := f.status[r.URL.Path]; status != 0 {
+			w.WriteHeader(status)
+		}
+		w.Write([]byte(f.responses[r.URL.Path]))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeApple) form(path string) url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.forms[path]
+}
+
+// useFakeApple points siwa at f through the environment and sets signing credentials
+func useFakeApple(t *testing.T, f *fakeApple) {
+	keyPath := writeSigningKey(t)
+	t.Setenv("SIWA_TEAM_ID", "TEAM000001")
+	t.Setenv("SIWA_KEY_ID", "KEY0000001")
+	t.Setenv("SIWA_CLIENT_ID", "com.example.web")
+	t.Setenv("SIWA_PRIVATE_KEY_PATH", keyPath)
+	t.Setenv("SIWA_VALIDATION_URL", f.URL+"/auth/token")
+	t.Setenv("SIWA_REVOKE_URL", f.URL+"/auth/revoke")
+	t.Setenv("SIWA_MIGRATION_URL", f.URL+"/auth/usermigrationinfo")
+}
+
+func TestExchange(t *testing.T) {
+	f := newFakeApple(t)
+	useFakeApple(t, f)
+
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user123", "email": "user@example.com"}).SignedString([]byte("k"))
+	require.NoError(t, err)
+	f.responses["/auth/token"] = `{"access_token":"access-token-value-0123456789","token_type":"bearer","expires_in":3600,"refresh_token":"refresh-token-value-0123456789","id_token":"` + idToken + `"}`
+
+	code, stdout, stderr := runCLI(t, "", "exchange", "-redirect-uri", "https://example.com/callback", "the-code")
+	require.Equal(t, exitOK, code, stderr)
+	form := f.form("/auth/token")
+	assert.Equal(t, "the-code", form.Get("code"))
+	assert.Equal(t, "https://example.com/callback", form.Get("redirect_uri"))
+	assert.Equal(t, "com.example.web", form.Get("client_id"))
+
+	info, err := apple.InspectClientSecret(form.Get("client_secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "TEAM000001", info.TeamID)
+	assert.LessOrEqual(t, info.ExpiresAt.Sub(info.IssuedAt), 5*time.Minute, "secrets for a single call are short-lived")
+
+	assert.Contains(t, stdout, "user123")
+	assert.Contains(t, stdout, "refres...[redacted, 30 chars]")
+	assert.NotContains(t, stdout, "refresh-token-value-0123456789")
+	assert.NotContains(t, stdout, idToken)
+	assert.NotContains(t, stdout+stderr, form.Get("client_secret"), "the client secret is never printed")
+
+	code, stdout, _ = runCLI(t, "", "exchange", "-json", "-show-secrets", "the-code")
+	require.Equal(t, exitOK, code)
+	assert.Empty(t, f.form("/auth/token").Get("redirect_uri"), "codes from apps are exchanged without a redirect URI")
+	var out tokenOutput
+	require.NoError(t, json.Unmarshal([]byte(stdout), &out))
+	assert.Equal(t, "refresh-token-value-0123456789", out.RefreshToken)
+	assert.Equal(t, "user@example.com", out.Claims.Email)
+}
+
+func TestRefreshAppleErrorExitCode(t *testing.T) {
+	f := newFakeApple(t)
+	useFakeApple(t, f)
+	f.status["/auth/token"] = http.StatusBadRequest
+	f.responses["/auth/token"] = `{"error":"invalid_grant","error_description":"The token has expired or has been revoked."}`
+
+	code, stdout, stderr := runCLI(t, "", "refresh", "the-refresh-token")
+	assert.Equal(t, 12, code)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "apple returned invalid_grant: The token has expired or has been revoked.")
+	assert.Equal(t, "the-refresh-token", f.form("/auth/token").Get("refresh_token"))
+
+	f.responses["/auth/token"] = `{"error":"something_new"}`
+	code, stdout, _ = runCLI(t, "", "refresh", "-json", "the-refresh-token")
+	assert.Equal(t, exitAppleError, code)
+	assert.Contains(t, stdout, `"error": "something_new"`)
+
+	f.status["/auth/token"] = 0
+	f.responses["/auth/token"] = `{"access_token":"new-access-token-0123456789","token_type":"bearer","expires_in":3600}`
+	code, stdout, _ = runCLI(t, "the-refresh-token\n", "refresh", "-")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "new-ac...[redacted")
+}
+
+func TestRevoke(t *testing.T) {
+	f := newFakeApple(t)
+	useFakeApple(t, f)
+
+	code, stdout, stderr := runCLI(t, "", "revoke", "-type", "access_token", "-client-secret", "given-secret", "the-access-token-value")
+	require.Equal(t, exitOK, code, stderr)
+	form := f.form("/auth/revoke")
+	assert.Equal(t, "access_token", form.Get("token_type_hint"))
+	assert.Equal(t, "given-secret", form.Get("client_secret"))
+	assert.Contains(t, stdout, "revoked access the-ac...[redacted")
+
+	f.status["/auth/revoke"] = http.StatusBadRequest
+	f.responses["/auth/revoke"] = `{"error":"invalid_client"}`
+	code, _, _ = runCLI(t, "", "revoke", "the-refresh-token")
+	assert.Equal(t, 11, code)
+	assert.Equal(t, "refresh_token", f.form("/auth/revoke").Get("token_type_hint"))
+
+	code, _, stderr = runCLI(t, "", "revoke", "-type", "id_token", "x")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "-type must be")
+}
+
+func TestMigrate(t *testing.T) {
+	f := newFakeApple(t)
+	useFakeApple(t, f)
+	f.responses["/auth/usermigrationinfo"] = `{"sub":"new.sub","email":"user@example.com","email_verified":true}`
+
+	code, stdout, stderr := runCLI(t, "", "migrate", "the-transfer-sub")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "the-transfer-sub", f.form("/auth/usermigrationinfo").Get("transfer_sub"))
+	assert.Contains(t, stdout, "new.sub")
+}
+
+func TestAPICommandsRequireCredentials(t *testing.T) {
+	for _, name := range []string{"SIWA_TEAM_ID", "SIWA_KEY_ID", "SIWA_CLIENT_ID", "SIWA_PRIVATE_KEY_PATH", "SIWA_PRIVATE_KEY", "SIWA_CLIENT_SECRET"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	code, _, stderr := runCLI(t, "", "refresh", "token")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "-client-id, -team-id, -key-id, -key required")
+
+	code, _, stderr = runCLI(t, "", "refresh", "-client-secret", "s", "token")
+	assert.Equal(t, exitUsage, code)
+	assert.True(t, strings.HasPrefix(stderr, "siwa refresh: -client-id required"), stderr)
+}
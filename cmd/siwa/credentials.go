@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Timothylock/go-signin-with-apple/apple"
+)
+
+// envPrefix prefixes the environment variables siwa reads, e.g. SIWA_TEAM_ID. The names match
+// apple.LoadConfigEnv so a service's configuration can be reused on the command line.
+const envPrefix = "SIWA_"
+
+// Environment variables that are specific to the command line
+const (
+	envClientID     = envPrefix + "CLIENT_ID"
+	envClientSecret = envPrefix + "CLIENT_SECRET"
+)
+
+// apiSecretLifetime is the lifetime of secrets generated for a single API call
+const apiSecretLifetime = 5 * time.Minute
+
+// credentials identify the caller to Apple, either as the parts of a client secret or as a ready-made one
+type credentials struct {
+	teamID       string
+	keyID        string
+	clientID     string
+	keyPath      string
+	key          string // PEM from the environment
+	clientSecret string
+}
+
+// credentialFlags registers the credential flags on fs. Values not given as flags are read from the
+// environment after parsing, so secrets held in the environment never show up in -h output.
+func credentialFlags(fs *flag.FlagSet, acceptSecret bool) *credentials {
+	cr := &credentials{}
+	fs.StringVar(&cr.teamID, "team-id", "", "10-character Team ID (env "+envPrefix+apple.EnvTeamID+")")
+	fs.StringVar(&cr.keyID, "key-id", "", "10-character Key ID of the signing key (env "+envPrefix+apple.EnvKeyID+")")
+	fs.StringVar(&cr.clientID, "client-id", "", "Services ID or bundle ID (env "+envClientID+")")
+	fs.StringVar(&cr.keyPath, "key", "", "path of the .p8 signing key (env "+envPrefix+apple.EnvPrivateKeyPath+", or the key itself in "+envPrefix+apple.EnvPrivateKey+")")
+	if acceptSecret {
+		fs.StringVar(&cr.clientSecret, "client-secret", "", "use this client secret instead of signing one (env "+envClientSecret+")")
+	}
+	return cr
+}
+
+// fromEnv fills in credentials that were not given as flags
+func (cr *credentials) fromEnv(acceptSecret bool) {
+	fill := func(field *string, name string) {
+		if *field == "" {
+			*field, _ = lookupEnv(name)
+		}
+	}
+	fill(&cr.teamID, envPrefix+apple.EnvTeamID)
+	fill(&cr.keyID, envPrefix+apple.EnvKeyID)
+	fill(&cr.clientID, envClientID)
+	fill(&cr.keyPath, envPrefix+apple.EnvPrivateKeyPath)
+	if cr.keyPath == "" {
+		cr.key = os.Getenv(envPrefix + apple.EnvPrivateKey)
+	}
+	if acceptSecret {
+		fill(&cr.clientSecret, envClientSecret)
+	}
+}
+
+// missing returns the flag names of the credentials required to sign a secret that are not set
+func (cr *credentials) missing(signing bool) []string {
+	var missing []string
+	if cr.clientID == "" {
+		missing = append(missing, "-client-id")
+	}
+	if !signing {
+		return missing
+	}
+	if cr.teamID == "" {
+		missing = append(missing, "-team-id")
+	}
+	if cr.keyID == "" {
+		missing = append(missing, "-key-id")
+	}
+	if cr.keyPath == "" && cr.key == "" {
+		missing = append(missing, "-key")
+	}
+	return missing
+}
+
+// sign generates a client secret from the credentials
+func (cr *credentials) sign(c *cli, lifetime time.Duration) (*apple.ClientSecret, error) {
+	key := cr.key
+	if cr.keyPath != "" {
+		data, err := os.ReadFile(cr.keyPath)
+		if err != nil {
+			return nil, err
+		}
+		key = string(data)
+	}
+	secret, err := apple.GenerateClientSecretWithOptions(key, apple.ClientSecretOptions{
+		TeamID:   cr.teamID,
+		ClientID: cr.clientID,
+		KeyID:    cr.keyID,
+		Lifetime: lifetime,
+		Clock:    clockFunc(c.now),
+	})
+	if err != nil && errors.Is(err, apple.ErrInvalidSigningKey) && cr.keyPath != "" {
+		err = fmt.Errorf("%s: %w", cr.keyPath, err)
+	}
+	return secret, err
+}
+
+// secret returns the client secret to send: the one given, or one signed for a single call
+func (cr *credentials) secret(c *cli) (string, error) {
+	if cr.clientSecret != "" {
+		return cr.clientSecret, nil
+	}
+	secret, err := cr.sign(c, apiSecretLifetime)
+	if err != nil {
+		return "", err
+	}
+	return secret.Token, nil
+}
+
+// redact shortens a token so output can be shared without leaking it
+func redact(token string) string {
+	if token == "" {
+		return ""
+	}
+	if len(token) <= 12 {
+		return fmt.Sprintf("[redacted, %d chars]", len(token))
+	}
+	return fmt.Sprintf("%s...[redacted, %d chars]", token[:6], len(token))
+}
+
+// lookupEnv returns the trimmed value of the environment variable name, treating empty values as unset
+func lookupEnv(name string) (string, bool) {
+	value := strings.TrimSpace(os.Getenv(name))
+	return value, value != ""
+}
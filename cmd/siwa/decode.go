@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Timothylock/go-signin-with-apple/apple"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenHeader is the part of a JOSE header siwa reports
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// decodedToken is the JSON output of decode and verify
+type decodedToken struct {
+	Valid     *bool                `json:"valid,omitempty"`
+	Error     string               `json:"error,omitempty"`
+	Header    *tokenHeader         `json:"header,omitempty"`
+	Claims    *apple.IDTokenClaims `json:"claims,omitempty"`
+	IssuedAt  *time.Time           `json:"issued_at,omitempty"`
+	ExpiresAt *time.Time           `json:"expires_at,omitempty"`
+	Expired   bool                 `json:"expired"`
+}
+
+func runDecode(c *cli, args []string) int {
+	fs := c.flagSet("decode", "<id_token | ->")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	token, err := c.tokenArg(fs)
+	if err != nil {
+		return c.usageError(fs, "%v", err)
+	}
+
+	decoded, err := c.decodeToken(token)
+	if err != nil {
+		return c.fail(fs.Name(), err)
+	}
+
+	if c.json {
+		if err := c.writeJSON(decoded); err != nil {
+			return c.fail(fs.Name(), err)
+		}
+		return exitOK
+	}
+	c.printToken(decoded)
+	fmt.Fprintln(c.stderr, "note: the signature was not verified; use \"siwa verify\" to check it")
+	return exitOK
+}
+
+func runVerify(c *cli, args []string) int {
+	fs := c.flagSet("verify", "<id_token | ->")
+	audience := fs.String("aud", "", "expected audience: the Services ID or bundle ID (required)")
+	keysURL := fs.String("keys-url", "", "JWKS endpoint to fetch Apple's public keys from (default "+apple.AppleKeysURL+")")
+	issuer := fs.String("issuer", "", "expected issuer (default "+apple.AppleIssuer+")")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for fetching Apple's public keys")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	if *audience == "" {
+		return c.usageError(fs, "-aud is required")
+	}
+	token, err := c.tokenArg(fs)
+	if err != nil {
+		return c.usageError(fs, "%v", err)
+	}
+
+	client := apple.NewWithOptions(apple.ClientOptions{
+		AppleKeysURL: *keysURL,
+		Issuer:       *issuer,
+		Clock:        clockFunc(c.now),
+		Client:       &http.Client{Timeout: *timeout},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	valid := true
+	_, verifyErr := client.VerifyIDToken(ctx, token, *audience)
+	if verifyErr != nil {
+		valid = false
+	}
+
+	decoded, err := c.decodeToken(token)
+	if err != nil {
+		return c.fail(fs.Name(), err)
+	}
+	decoded.Valid = &valid
+	if verifyErr != nil {
+		decoded.Error = verifyErr.Error()
+	}
+
+	if c.json {
+		if err := c.writeJSON(decoded); err != nil {
+			return c.fail(fs.Name(), err)
+		}
+	} else {
+		if valid {
+			fmt.Fprintln(c.stdout, "token is valid")
+		} else {
+			fmt.Fprintf(c.stdout, "token is NOT valid: %v\n", verifyErr)
+		}
+		c.printToken(decoded)
+	}
+
+	if !valid {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// decodeToken decodes token without verifying it
+func (c *cli) decodeToken(token string) (*decodedToken, error) {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+	claims, err := apple.GetTypedClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded := &decodedToken{Header: &tokenHeader{}, Claims: claims}
+	decoded.Header.Alg, _ = parsed.Header["alg"].(string)
+	decoded.Header.Kid, _ = parsed.Header["kid"].(string)
+	if claims.IssuedAt != 0 {
+		iat := time.Unix(claims.IssuedAt, 0).UTC()
+		decoded.IssuedAt = &iat
+	}
+	if claims.ExpiresAt != 0 {
+		exp := time.Unix(claims.ExpiresAt, 0).UTC()
+		decoded.ExpiresAt = &exp
+		decoded.Expired = !exp.After(c.now())
+	}
+	return decoded, nil
+}
+
+// printToken prints a decoded token as text
+func (c *cli) printToken(d *decodedToken) {
+	rows := [][2]string{
+		{"alg", d.Header.Alg},
+		{"kid", d.Header.Kid},
+		{"iss", d.Claims.Issuer},
+		{"aud", d.Claims.Audience},
+		{"sub", d.Claims.Subject},
+	}
+	if d.Claims.Email != "" {
+		rows = append(rows,
+			[2]string{"email", d.Claims.Email},
+			[2]string{"email_verified", strconv.FormatBool(d.Claims.EmailVerified)},
+			[2]string{"is_private_email", strconv.FormatBool(d.Claims.IsPrivateEmail)},
+		)
+	}
+	if d.Claims.RealUserStatus != 0 {
+		rows = append(rows, [2]string{"real_user_status", realUserStatus(d.Claims.RealUserStatus)})
+	}
+	if d.Claims.Nonce != "" {
+		rows = append(rows, [2]string{"nonce", d.Claims.Nonce})
+	}
+	if d.Claims.AuthTime != 0 {
+		rows = append(rows, [2]string{"auth_time", c.humanTime(time.Unix(d.Claims.AuthTime, 0))})
+	}
+	if d.IssuedAt != nil {
+		rows = append(rows, [2]string{"iat", c.humanTime(*d.IssuedAt)})
+	}
+	if d.ExpiresAt != nil {
+		exp := c.humanTime(*d.ExpiresAt)
+		if d.Expired {
+			exp += " EXPIRED"
+		}
+		rows = append(rows, [2]string{"exp", exp})
+	}
+	c.table(rows)
+}
+
+func realUserStatus(status int) string {
+	switch status {
+	case 0:
+		return "0 (unsupported)"
+	case 1:
+		return "1 (unknown)"
+	case 2:
+		return "2 (likely real)"
+	default:
+		return strconv.Itoa(status)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Timothylock/go-signin-with-apple/apple"
+)
+
+func runInspectSecret(c *cli, args []string) int {
+	fs := c.flagSet("inspect-secret", "<client_secret | ->")
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	secret, err := c.tokenArg(fs)
+	if err != nil {
+		return c.usageError(fs, "%v", err)
+	}
+
+	info, err := apple.InspectClientSecretWithClock(secret, clockFunc(c.now))
+	if err != nil {
+		return c.fail(fs.Name(), err)
+	}
+
+	if c.json {
+		if err := c.writeJSON(struct {
+			TeamID      string    `json:"team_id"`
+			ClientID    string    `json:"client_id"`
+			KeyID       string    `json:"key_id"`
+			Audience    string    `json:"audience"`
+			IssuedAt    time.Time `json:"issued_at"`
+			ExpiresAt   time.Time `json:"expires_at"`
+			SecondsLeft int64     `json:"seconds_to_expiry"`
+			Expired     bool      `json:"expired"`
+		}{info.TeamID, info.ClientID, info.KeyID, info.Audience, info.IssuedAt, info.ExpiresAt, int64(info.TimeToExpiry / time.Second), info.Expired()}); err != nil {
+			return c.fail(fs.Name(), err)
+		}
+	} else {
+		exp := c.humanTime(info.ExpiresAt)
+		if info.Expired() {
+			exp += " EXPIRED"
+		}
+		c.table([][2]string{
+			{"team ID", info.TeamID},
+			{"client ID", info.ClientID},
+			{"key ID", info.KeyID},
+			{"audience", info.Audience},
+			{"issued", c.humanTime(info.IssuedAt)},
+			{"expires", exp},
+		})
+	}
+
+	if !info.AppleAudience() {
+		fmt.Fprintf(c.stderr, "siwa inspect-secret: audience is %q, not %s; Apple's production endpoints reject this secret\n", info.Audience, apple.AppleIssuer)
+	}
+	if info.Expired() {
+		fmt.Fprintln(c.stderr, "siwa inspect-secret: client secret has expired")
+		return exitFailure
+	}
+	return exitOK
+}
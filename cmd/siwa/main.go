@@ -0,0 +1,227 @@
+// Command siwa generates Sign in with Apple client secrets and inspects the tokens Apple issues.
+//
+// Usage:
+//
+//	siwa <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	secret          generate a client secret from a .p8 signing key
+//	decode          print the header and claims of an id_token without verifying it
+//	verify          verify an id_token's signature, issuer, audience and expiry
+//	inspect-secret  print who a client secret identifies and when it expires
+//	exchange        exchange an authorization code for tokens
+//	refresh         validate a refresh token and get a new access token
+//	revoke          revoke a refresh token or access token
+//	migrate         get a transferred user's identifier for this team
+//
+// Every command accepts -json to print machine-readable output. Tokens given as "-" are read from
+// standard input. Run "siwa <command> -h" for the flags of a command.
+//
+// Credentials are taken from flags, falling back to the environment variables SIWA_TEAM_ID,
+// SIWA_KEY_ID, SIWA_CLIENT_ID and SIWA_PRIVATE_KEY_PATH or SIWA_PRIVATE_KEY. Commands that call
+// Apple sign a short-lived client secret unless one is given with -client-secret or
+// SIWA_CLIENT_SECRET. Tokens in their output are redacted unless -show-secrets is set.
+//
+// siwa exits with 0 on success, 1 when a command fails, and 2 on usage errors. When Apple answers
+// with an error, the exit code identifies it: 10 invalid_request, 11 invalid_client, 12 invalid_grant,
+// 13 unauthorized_client, 14 unsupported_grant_type, 15 invalid_scope and 19 for any other error.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// command is a siwa subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(c *cli, args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"secret", "generate a client secret from a .p8 signing key", runSecret},
+		{"decode", "print the header and claims of an id_token without verifying it", runDecode},
+		{"verify", "verify an id_token's signature, issuer, audience and expiry", runVerify},
+		{"inspect-secret", "print who a client secret identifies and when it expires", runInspectSecret},
+		{"exchange", "exchange an authorization code for tokens", runExchange},
+		{"refresh", "validate a refresh token and get a new access token", runRefresh},
+		{"revoke", "revoke a refresh token or access token", runRevoke},
+		{"migrate", "get a transferred user's identifier for this team", runMigrate},
+	}
+}
+
+// cli carries the process environment so commands can be tested without touching the real one
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	now    func() time.Time
+	json   bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr, now: time.Now}
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		c.usage(stderr)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(c, args[1:])
+		}
+	}
+	fmt.Fprintf(stderr, "siwa: unknown command %q\n\n", args[0])
+	c.usage(stderr)
+	return exitUsage
+}
+
+func (c *cli) usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: siwa <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-15s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "siwa <command> -h" for the flags of a command.`)
+}
+
+// flagSet returns a flag set for the named command with the shared -json flag registered
+func (c *cli) flagSet(name, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.BoolVar(&c.json, "json", false, "print JSON instead of text")
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: siwa %s [flags] %s\n\nFlags:\n", name, arguments)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args and reports the exit code to return if parsing failed
+func (c *cli) parse(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// usageError reports a usage mistake for the command and returns exitUsage
+func (c *cli) usageError(fs *flag.FlagSet, format string, args ...interface{}) int {
+	fmt.Fprintf(c.stderr, "siwa %s: %s\n", fs.Name(), fmt.Sprintf(format, args...))
+	fs.Usage()
+	return exitUsage
+}
+
+// fail reports an error for the command and returns exitFailure
+func (c *cli) fail(name string, err error) int {
+	fmt.Fprintf(c.stderr, "siwa %s: %v\n", name, err)
+	return exitFailure
+}
+
+// tokenArg returns the single token argument, reading it from stdin when it is "-"
+func (c *cli) tokenArg(fs *flag.FlagSet) (string, error) {
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("expected exactly one token argument, got %d", fs.NArg())
+	}
+	token := fs.Arg(0)
+	if token == "-" {
+		data, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return "", fmt.Errorf("reading token from stdin: %w", err)
+		}
+		token = string(data)
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", errors.New("token is empty")
+	}
+	return token, nil
+}
+
+// writeJSON prints v as indented JSON
+func (c *cli) writeJSON(v interface{}) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := c.stdout.Write(buf.Bytes())
+	return err
+}
+
+// table prints aligned "label: value" lines
+func (c *cli) table(rows [][2]string) {
+	width := 0
+	for _, row := range rows {
+		if len(row[0]) > width {
+			width = len(row[0])
+		}
+	}
+	for _, row := range rows {
+		fmt.Fprintf(c.stdout, "%-*s  %s\n", width+1, row[0]+":", row[1])
+	}
+}
+
+// humanTime formats t with its distance from now, e.g. "2026-01-02T15:04:05Z (in 2h0m0s)"
+func (c *cli) humanTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := t.Sub(c.now()).Round(time.Second)
+	rel := "now"
+	switch {
+	case d > 0:
+		rel = "in " + humanDuration(d)
+	case d < 0:
+		rel = humanDuration(-d) + " ago"
+	}
+	return fmt.Sprintf("%s (%s)", t.UTC().Format(time.RFC3339), rel)
+}
+
+// humanDuration formats d using days for long durations, e.g. "179d23h59m59s"
+func humanDuration(d time.Duration) string {
+	const day = 24 * time.Hour
+	if d < day {
+		return d.String()
+	}
+	days := d / day
+	rest := d - days*day
+	if rest == 0 {
+		return fmt.Sprintf("%dd", days)
+	}
+	return fmt.Sprintf("%dd%s", days, rest)
+}
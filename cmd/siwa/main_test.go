@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Timothylock/go-signin-with-apple/apple"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runCLI runs siwa with args and returns the exit code, stdout and stderr
+func runCLI(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func writeSigningKey(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "AuthKey_KEY0000001.p8")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return path
+}
+
+// newTestIDToken returns an id_token signed by a fresh RSA key and a JWKS server for that key
+func newTestIDToken(t *testing.T, claims jwt.MapClaims) (string, *httptest.Server) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwk, err := apple.NewJWK(&key.PublicKey, "test-kid", "RS256")
+	require.NoError(t, err)
+	body, err := (&apple.JWKS{Keys: []apple.JWK{jwk}}).Marshal()
+	require.NoError(t, err)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-kid"
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed, srv
+}
+
+func TestRunUsage(t *testing.T) {
+	code, _, stderr := runCLI(t, "")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "inspect-secret")
+
+	code, _, stderr = runCLI(t, "", "frobnicate")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, `unknown command "frobnicate"`)
+
+	code, _, _ = runCLI(t, "", "help")
+	assert.Equal(t, exitOK, code)
+
+	code, _, stderr = runCLI(t, "", "secret", "-h")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stderr, "-team-id")
+}
+
+func TestSecretAndInspectSecret(t *testing.T) {
+	keyPath := writeSigningKey(t)
+
+	code, _, stderr := runCLI(t, "", "secret", "-team-id", "TEAM000001", "-key-id", "KEY0000001", "-key", keyPath)
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "-client-id required")
+
+	code, stdout, stderr := runCLI(t, "", "secret", "-team-id", "TEAM000001", "-key-id", "KEY0000001", "-client-id", "com.example.web", "-key", keyPath, "-lifetime", "24h")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "expires")
+	secret := strings.TrimSpace(stdout)
+	assert.Equal(t, 3, len(strings.Split(secret, ".")), "only the secret is printed to stdout")
+
+	code, stdout, _ = runCLI(t, secret, "inspect-secret", "-")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "TEAM000001")
+	assert.Contains(t, stdout, "com.example.web")
+	assert.Regexp(t, `expires: +\S+ \(in (1d|23h59m\d+s)\)`, stdout)
+
+	code, stdout, _ = runCLI(t, "", "inspect-secret", "-json", secret)
+	require.Equal(t, exitOK, code)
+	var info map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &info))
+	assert.Equal(t, "KEY0000001", info["key_id"])
+	assert.Equal(t, false, info["expired"])
+
+	code, stdout, _ = runCLI(t, "", "secret", "-json", "-team-id", "TEAM000001", "-key-id", "KEY0000001", "-client-id", "com.example.web", "-key", keyPath)
+	require.Equal(t, exitOK, code)
+	var generated map[string]string
+	require.NoError(t, json.Unmarshal([]byte(stdout), &generated))
+	assert.NotEmpty(t, generated["client_secret"])
+	assert.NotEmpty(t, generated["expires_at"])
+
+	code, _, stderr = runCLI(t, "", "inspect-secret", "not-a-secret")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "invalid client secret")
+
+	signer, err := apple.GenerateSoftwareSigner()
+	require.NoError(t, err)
+	sandbox, err := apple.SignClientSecret(signer, apple.ClientSecretOptions{TeamID: "TEAM000001", ClientID: "com.example.web", KeyID: "KEY0000001", Audience: "https://sandbox.example.com"})
+	require.NoError(t, err)
+	code, stdout, stderr = runCLI(t, "", "inspect-secret", sandbox.Token)
+	assert.Equal(t, exitOK, code, "secrets for a sandbox audience are reported, not rejected")
+	assert.Contains(t, stdout, "https://sandbox.example.com")
+	assert.Contains(t, stderr, `audience is "https://sandbox.example.com"`)
+}
+
+func TestSecretRejectsWrongKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "rsa.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600))
+
+	code, stdout, stderr := runCLI(t, "", "secret", "-team-id", "TEAM000001", "-key-id", "KEY0000001", "-client-id", "com.example.web", "-key", path)
+	assert.Equal(t, exitFailure, code)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "RSA key")
+}
+
+func TestDecode(t *testing.T) {
+	token, _ := newTestIDToken(t, jwt.MapClaims{
+		"iss":            apple.AppleIssuer,
+		"aud":            "com.example.app",
+		"sub":            "user123",
+		"email":          "user@privaterelay.appleid.com",
+		"email_verified": "true",
+		"iat":            time.Now().Add(-2 * time.Hour).Unix(),
+		"exp":            time.Now().Add(-time.Hour).Unix(),
+	})
+
+	code, stdout, stderr := runCLI(t, "", "decode", token)
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "RS256")
+	assert.Contains(t, stdout, "test-kid")
+	assert.Contains(t, stdout, "user123")
+	assert.Contains(t, stdout, "EXPIRED")
+	assert.Contains(t, stdout, "ago)")
+	assert.Contains(t, stderr, "not verified")
+
+	code, stdout, _ = runCLI(t, token+"\n", "decode", "-json", "-")
+	require.Equal(t, exitOK, code)
+	var decoded decodedToken
+	require.NoError(t, json.Unmarshal([]byte(stdout), &decoded))
+	assert.Equal(t, "test-kid", decoded.Header.Kid)
+	assert.Equal(t, "user123", decoded.Claims.Subject)
+	assert.True(t, decoded.Claims.EmailVerified)
+	assert.True(t, decoded.Expired)
+
+	code, _, _ = runCLI(t, "", "decode")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestVerify(t *testing.T) {
+	token, srv := newTestIDToken(t, jwt.MapClaims{
+		"iss": apple.AppleIssuer,
+		"aud": "com.example.app",
+		"sub": "user123",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	code, stdout, stderr := runCLI(t, "", "verify", "-aud", "com.example.app", "-keys-url", srv.URL, token)
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "token is valid")
+	assert.Contains(t, stdout, "user123")
+
+	code, stdout, _ = runCLI(t, "", "verify", "-json", "-aud", "com.example.other", "-keys-url", srv.URL, token)
+	assert.Equal(t, exitFailure, code)
+	var decoded decodedToken
+	require.NoError(t, json.Unmarshal([]byte(stdout), &decoded))
+	require.NotNil(t, decoded.Valid)
+	assert.False(t, *decoded.Valid)
+	assert.Contains(t, decoded.Error, "aud")
+
+	code, _, stderr = runCLI(t, "", "verify", token)
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "-aud is required")
+}
+
+func TestHumanDuration(t *testing.T) {
+	assert.Equal(t, "1h30m0s", humanDuration(90*time.Minute))
+	assert.Equal(t, "2d", humanDuration(48*time.Hour))
+	assert.Equal(t, "179d23h59m59s", humanDuration(apple.DefaultClientSecretLifetime))
+}
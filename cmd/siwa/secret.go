@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Timothylock/go-signin-with-apple/apple"
+)
+
+func runSecret(c *cli, args []string) int {
+	fs := c.flagSet("secret", "")
+	cr := credentialFlags(fs, false)
+	lifetime := fs.Duration("lifetime", apple.DefaultClientSecretLifetime, "lifetime of the secret, at most "+apple.MaxClientSecretLifetime.String())
+	if code, ok := c.parse(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		return c.usageError(fs, "unexpected arguments %q", fs.Args())
+	}
+	cr.fromEnv(false)
+	if missing := cr.missing(true); len(missing) > 0 {
+		return c.usageError(fs, "%s required", strings.Join(missing, ", "))
+	}
+
+	secret, err := cr.sign(c, *lifetime)
+	if err != nil {
+		return c.fail(fs.Name(), err)
+	}
+
+	if c.json {
+		if err := c.writeJSON(struct {
+			ClientSecret string    `json:"client_secret"`
+			IssuedAt     time.Time `json:"issued_at"`
+			ExpiresAt    time.Time `json:"expires_at"`
+		}{secret.Token, secret.IssuedAt, secret.ExpiresAt}); err != nil {
+			return c.fail(fs.Name(), err)
+		}
+		return exitOK
+	}
+
+	// The secret alone goes to stdout so it can be piped or captured
+	fmt.Fprintln(c.stdout, secret.Token)
+	fmt.Fprintf(c.stderr, "expires %s\n", c.humanTime(secret.ExpiresAt))
+	return exitOK
+}
+
+// clockFunc adapts a time function to apple.Clock
+type clockFunc func() time.Time
+
+func (f clockFunc) Now() time.Time { return f() }